@@ -1,5 +1,7 @@
 package main
 
+import "sync/atomic"
+
 // Expr is the interface for all expression types
 type Expr interface {
 	Accept(visitor ExprVisitor) interface{}
@@ -19,6 +21,9 @@ type ExprVisitor interface {
 	VisitSetExpr(expr *Set) interface{}
 	VisitThisExpr(expr *This) interface{}
 	VisitSuperExpr(expr *Super) interface{}
+	VisitIndexExpr(expr *Index) interface{}
+	VisitSpawnExpr(expr *Spawn) interface{}
+	VisitAwaitExpr(expr *Await) interface{}
 }
 
 // Literal represents a literal value expression
@@ -105,6 +110,7 @@ func (c *Call) Accept(visitor ExprVisitor) interface{} {
 type Get struct {
 	Object Expr
 	Name   Token
+	cache  atomic.Pointer[methodCache] // inline cache of the method this site resolved to
 }
 
 func (g *Get) Accept(visitor ExprVisitor) interface{} {
@@ -140,3 +146,34 @@ type Super struct {
 func (s *Super) Accept(visitor ExprVisitor) interface{} {
 	return visitor.VisitSuperExpr(s)
 }
+
+// Index represents a subscript expression
+type Index struct {
+	Object  Expr
+	Bracket Token
+	Index   Expr
+}
+
+func (i *Index) Accept(visitor ExprVisitor) interface{} {
+	return visitor.VisitIndexExpr(i)
+}
+
+// Spawn represents a function call started as a concurrent task
+type Spawn struct {
+	Keyword Token
+	Call    *Call
+}
+
+func (s *Spawn) Accept(visitor ExprVisitor) interface{} {
+	return visitor.VisitSpawnExpr(s)
+}
+
+// Await represents waiting for a promise to settle
+type Await struct {
+	Keyword Token
+	Value   Expr
+}
+
+func (a *Await) Accept(visitor ExprVisitor) interface{} {
+	return visitor.VisitAwaitExpr(a)
+}
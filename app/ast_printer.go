@@ -1,8 +1,13 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
-// AstPrinter implements the ExprVisitor interface to print expressions
+// AstPrinter implements the ExprVisitor and StmtVisitor interfaces to print
+// expressions and whole programs as S-expressions
 type AstPrinter struct{}
 
 func NewAstPrinter() *AstPrinter {
@@ -26,6 +31,15 @@ func (p *AstPrinter) VisitLiteralExpr(expr *Literal) interface{} {
 		return fmt.Sprintf("%t", b)
 	}
 
+	// Numbers always keep at least one decimal place, matching the scanner
+	if num, ok := expr.Value.(float64); ok {
+		literal := strconv.FormatFloat(num, 'f', -1, 64)
+		if !strings.Contains(literal, ".") {
+			literal += ".0"
+		}
+		return literal
+	}
+
 	// For other values, use default formatting
 	return fmt.Sprintf("%v", expr.Value)
 }
@@ -102,3 +116,197 @@ func (p *AstPrinter) VisitThisExpr(expr *This) interface{} {
 func (p *AstPrinter) VisitSuperExpr(expr *Super) interface{} {
 	return fmt.Sprintf("(super %s)", expr.Method.Lexeme)
 }
+
+// VisitIndexExpr formats a subscript expression
+func (p *AstPrinter) VisitIndexExpr(expr *Index) interface{} {
+	objectExpr := expr.Object.Accept(p).(string)
+	indexExpr := expr.Index.Accept(p).(string)
+	return fmt.Sprintf("(index %s %s)", objectExpr, indexExpr)
+}
+
+// VisitSpawnExpr formats a spawn expression
+func (p *AstPrinter) VisitSpawnExpr(expr *Spawn) interface{} {
+	callExpr := expr.Call.Accept(p).(string)
+	return fmt.Sprintf("(spawn %s)", callExpr)
+}
+
+// VisitAwaitExpr formats an await expression
+func (p *AstPrinter) VisitAwaitExpr(expr *Await) interface{} {
+	valueExpr := expr.Value.Accept(p).(string)
+	return fmt.Sprintf("(await %s)", valueExpr)
+}
+
+// PrintProgram converts a list of statements to S-expressions, one top-level
+// statement per line. Statements nested in blocks, branches, loops, functions and
+// classes go on their own lines, indented under the statement containing them.
+func (p *AstPrinter) PrintProgram(statements []Stmt) string {
+	lines := make([]string, len(statements))
+	for index, stmt := range statements {
+		lines[index] = p.printStmt(stmt)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// printStmt converts a statement to its string representation
+func (p *AstPrinter) printStmt(stmt Stmt) string {
+	return stmt.Accept(p).(string)
+}
+
+// nested formats a form whose head stays on the first line and whose children go
+// on the following lines, indented
+func (p *AstPrinter) nested(head string, children []string) string {
+	var builder strings.Builder
+	builder.WriteString("(" + head)
+	for _, child := range children {
+		builder.WriteString("\n  ")
+		builder.WriteString(strings.ReplaceAll(child, "\n", "\n  "))
+	}
+	builder.WriteString(")")
+	return builder.String()
+}
+
+// printStmts converts each statement in a list
+func (p *AstPrinter) printStmts(statements []Stmt) []string {
+	printed := make([]string, len(statements))
+	for index, stmt := range statements {
+		printed[index] = p.printStmt(stmt)
+	}
+	return printed
+}
+
+// printFunction formats a function or method declaration under the given keyword
+func (p *AstPrinter) printFunction(keyword string, function *Function) string {
+	if function.IsGenerator {
+		keyword += "*"
+	}
+	if function.IsAsync {
+		keyword = "async " + keyword
+	}
+	params := make([]string, len(function.Params))
+	for index, param := range function.Params {
+		params[index] = param.Lexeme
+	}
+	head := fmt.Sprintf("%s %s (%s)", keyword, function.Name.Lexeme, strings.Join(params, " "))
+	return p.nested(head, p.printStmts(function.Body))
+}
+
+// VisitPrintStmt formats a print statement
+func (p *AstPrinter) VisitPrintStmt(stmt *Print) interface{} {
+	return fmt.Sprintf("(print %s)", p.Print(stmt.Expression))
+}
+
+// VisitExpressionStmt formats an expression statement
+func (p *AstPrinter) VisitExpressionStmt(stmt *Expression) interface{} {
+	return fmt.Sprintf("(expr %s)", p.Print(stmt.Expression))
+}
+
+// VisitVarStmt formats a variable declaration
+func (p *AstPrinter) VisitVarStmt(stmt *Var) interface{} {
+	if stmt.Initializer == nil {
+		return fmt.Sprintf("(var %s)", stmt.Name.Lexeme)
+	}
+	return fmt.Sprintf("(var %s %s)", stmt.Name.Lexeme, p.Print(stmt.Initializer))
+}
+
+// VisitBlockStmt formats a block
+func (p *AstPrinter) VisitBlockStmt(stmt *Block) interface{} {
+	return p.nested("block", p.printStmts(stmt.Statements))
+}
+
+// VisitIfStmt formats an if statement, with its else branch if it has one
+func (p *AstPrinter) VisitIfStmt(stmt *If) interface{} {
+	branches := []string{p.printStmt(stmt.ThenBranch)}
+	if stmt.ElseBranch != nil {
+		branches = append(branches, p.printStmt(stmt.ElseBranch))
+	}
+	return p.nested("if "+p.Print(stmt.Condition), branches)
+}
+
+// VisitWhileStmt formats a while loop. A loop desugared from a for loop shows its
+// increment after the body, where it runs.
+func (p *AstPrinter) VisitWhileStmt(stmt *While) interface{} {
+	children := []string{p.printStmt(stmt.Body)}
+	if stmt.Increment != nil {
+		children = append(children, fmt.Sprintf("(increment %s)", p.Print(stmt.Increment)))
+	}
+	return p.nested("while "+p.Print(stmt.Condition), children)
+}
+
+// VisitForInStmt formats a for-in loop
+func (p *AstPrinter) VisitForInStmt(stmt *ForIn) interface{} {
+	head := fmt.Sprintf("for-in %s %s", stmt.Name.Lexeme, p.Print(stmt.Iterable))
+	return p.nested(head, []string{p.printStmt(stmt.Body)})
+}
+
+// VisitFunctionStmt formats a function declaration
+func (p *AstPrinter) VisitFunctionStmt(stmt *Function) interface{} {
+	return p.printFunction("fun", stmt)
+}
+
+// VisitReturnStmt formats a return statement
+func (p *AstPrinter) VisitReturnStmt(stmt *Return) interface{} {
+	if stmt.Value == nil {
+		return "(return)"
+	}
+	return fmt.Sprintf("(return %s)", p.Print(stmt.Value))
+}
+
+// VisitBreakStmt formats a break statement
+func (p *AstPrinter) VisitBreakStmt(stmt *Break) interface{} {
+	return "(break)"
+}
+
+// VisitContinueStmt formats a continue statement
+func (p *AstPrinter) VisitContinueStmt(stmt *Continue) interface{} {
+	return "(continue)"
+}
+
+// VisitYieldStmt formats a yield statement
+func (p *AstPrinter) VisitYieldStmt(stmt *Yield) interface{} {
+	if stmt.Value == nil {
+		return "(yield)"
+	}
+	return fmt.Sprintf("(yield %s)", p.Print(stmt.Value))
+}
+
+// VisitClassStmt formats a class declaration: its superclass and traits on the
+// first line, then its fields and each kind of method
+func (p *AstPrinter) VisitClassStmt(stmt *Class) interface{} {
+	head := "class " + stmt.Name.Lexeme
+	if stmt.Superclass != nil {
+		head += " < " + stmt.Superclass.Name.Lexeme
+	}
+	if len(stmt.Traits) > 0 {
+		head += " with"
+		for _, trait := range stmt.Traits {
+			head += " " + trait.Name.Lexeme
+		}
+	}
+
+	var members []string
+	for _, field := range stmt.Fields {
+		members = append(members, p.printStmt(field))
+	}
+	for _, method := range stmt.Methods {
+		members = append(members, p.printFunction("method", method))
+	}
+	for _, method := range stmt.ClassMethods {
+		members = append(members, p.printFunction("class-method", method))
+	}
+	for _, getter := range stmt.Getters {
+		members = append(members, p.printFunction("getter", getter))
+	}
+	for _, setter := range stmt.Setters {
+		members = append(members, p.printFunction("setter", setter))
+	}
+	return p.nested(head, members)
+}
+
+// VisitTraitStmt formats a trait declaration
+func (p *AstPrinter) VisitTraitStmt(stmt *Trait) interface{} {
+	methods := make([]string, len(stmt.Methods))
+	for index, method := range stmt.Methods {
+		methods[index] = p.printFunction("method", method)
+	}
+	return p.nested("trait "+stmt.Name.Lexeme, methods)
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+// printProgram parses a whole program and prints it as the parse command does
+func printProgram(t *testing.T, source string) string {
+	t.Helper()
+	scanner := NewScanner(source)
+	scanner.AllowExtensions()
+	parser := NewParser(scanner.ScanTokens())
+	statements := parser.ParseStatements()
+	if scanner.HasError() || parser.HasError() {
+		t.Fatalf("program doesn't parse:\n%s", source)
+	}
+	return NewAstPrinter().PrintProgram(statements)
+}
+
+func TestPrintProgramStatements(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", ""},
+		{"var a = 1 + 2 * 3; var b;", "(var a (+ 1.0 (* 2.0 3.0)))\n(var b)"},
+		{
+			"for (var i = 0; i < 3; i = i + 1) { if (i == 1) continue; else print i; }",
+			"(block\n  (var i 0.0)\n  (while (< i 3.0)\n    (block\n      (if (== i 1.0)\n        (continue)\n        (print i)))\n    (increment (= i (+ i 1.0)))))",
+		},
+		{"fun* gen(n) { yield n; return; }", "(fun* gen (n)\n  (yield n)\n  (return))"},
+		{"async fun f() { await delay(1); }", "(async fun f ()\n  (expr (await (call delay 1.0))))"},
+		{"trait T { hello() { print \"hi\"; } }", "(trait T\n  (method hello ()\n    (print hi)))"},
+		{
+			"class B < A with T { var x = 1; class make() { return B(); } size { return 1; } set size(v) {} }",
+			"(class B < A with T\n  (var x 1.0)\n  (class-method make ()\n    (return (call B )))\n  (getter size ()\n    (return 1.0))\n  (setter size (v)))",
+		},
+		{"class E {}", "(class E)"},
+		{"for (var o in list(1, 2)) { break; }", "(for-in o (call list 1.0 2.0)\n  (block\n    (break)))"},
+		{"while (true) {}", "(while true\n  (block))"},
+	}
+	for _, test := range tests {
+		if got := printProgram(t, test.source); got != test.want {
+			t.Errorf("%s:\ngot\n%s\nwant\n%s", test.source, got, test.want)
+		}
+	}
+}
+
+func TestPrintExpressionIsUnchanged(t *testing.T) {
+	parser := NewParser(NewScanner("(1 + 2) * -x").ScanTokens())
+	expr := parser.Parse()
+	if parser.HasError() {
+		t.Fatal("expression doesn't parse")
+	}
+	if got, want := NewAstPrinter().Print(expr), "(* (group (+ 1.0 2.0)) (- x))"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// promiseOutcome is the settled result of a promise: a value, or the runtime
+// error that rejected it
+type promiseOutcome struct {
+	value    interface{}
+	rejected bool
+	message  string
+	line     int
+}
+
+// LoxPromise is the eventual result of an async function call or a then()
+// callback. Callbacks registered with onSettle are never run inline; they are
+// queued on the event loop once the promise settles.
+type LoxPromise struct {
+	loop      *eventLoop
+	mu        sync.Mutex
+	settled   bool
+	outcome   promiseOutcome
+	handled   bool // something awaited the promise or attached a callback
+	callbacks []promiseCallback
+}
+
+// promiseCallback is a callback waiting for a promise to settle, with the values
+// it will use, which stay reachable until it has run
+type promiseCallback struct {
+	run     func(promiseOutcome)
+	retains []interface{}
+}
+
+func newLoxPromise(loop *eventLoop) *LoxPromise {
+	return &LoxPromise{loop: loop}
+}
+
+func (p *LoxPromise) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case !p.settled:
+		return "<promise pending>"
+	case p.outcome.rejected:
+		return "<promise rejected>"
+	default:
+		return "<promise fulfilled>"
+	}
+}
+
+// isSettled reports whether the promise has been fulfilled or rejected
+func (p *LoxPromise) isSettled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.settled
+}
+
+// settle records the outcome and queues the registered callbacks. Only the first
+// outcome counts.
+func (p *LoxPromise) settle(outcome promiseOutcome) {
+	p.mu.Lock()
+	if p.settled {
+		p.mu.Unlock()
+		return
+	}
+	p.settled = true
+	p.outcome = outcome
+	callbacks := p.callbacks
+	p.callbacks = nil
+	p.mu.Unlock()
+
+	if outcome.rejected {
+		p.loop.trackRejection(p)
+	}
+	for _, callback := range callbacks {
+		p.loop.enqueue(func(*Interpreter) { callback.run(outcome) }, callback.retains...)
+	}
+}
+
+// resolve fulfills the promise, adopting the outcome if the value is itself a promise
+func (p *LoxPromise) resolve(value interface{}) {
+	if inner, ok := value.(*LoxPromise); ok {
+		inner.onSettle(p.settle, p)
+		return
+	}
+	p.settle(promiseOutcome{value: value})
+}
+
+// fail rejects the promise with a runtime error raised while producing its value
+func (p *LoxPromise) fail(message string, line int) {
+	p.settle(promiseOutcome{rejected: true, message: message, line: line})
+}
+
+// onSettle registers a callback for the outcome and marks the promise as handled.
+// The values the callback retains stay reachable until it has run.
+func (p *LoxPromise) onSettle(callback func(promiseOutcome), retains ...interface{}) {
+	p.mu.Lock()
+	p.handled = true
+	if !p.settled {
+		p.callbacks = append(p.callbacks, promiseCallback{run: callback, retains: retains})
+		p.mu.Unlock()
+		return
+	}
+	outcome := p.outcome
+	p.mu.Unlock()
+	p.loop.enqueue(func(*Interpreter) { callback(outcome) }, retains...)
+}
+
+// roots returns the values the promise keeps reachable: its value once fulfilled,
+// and the values its pending callbacks retain
+func (p *LoxPromise) roots() []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var roots []interface{}
+	if p.settled && !p.outcome.rejected {
+		roots = append(roots, p.outcome.value)
+	}
+	for _, callback := range p.callbacks {
+		roots = append(roots, callback.retains...)
+	}
+	return roots
+}
+
+// promiseMethod returns the built-in method with the given name bound to the
+// promise, or nil
+func promiseMethod(promise *LoxPromise, name string) *NativeFunction {
+	switch name {
+	case "then":
+		// then(callback) calls callback with the fulfilled value and returns a promise
+		// for its result; a rejection skips the callback and passes straight through
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			callback, ok := arguments[0].(LoxCallable)
+			if !ok || (callback.Arity() >= 0 && callback.Arity() != 1) {
+				return nil, fmt.Errorf("then() expects a function with one parameter.")
+			}
+
+			derived := newLoxPromise(promise.loop)
+			promise.onSettle(func(outcome promiseOutcome) {
+				if outcome.rejected {
+					derived.settle(outcome)
+					return
+				}
+				worker := interpreter.fork(interpreter.globals)
+				worker.errorSink = derived
+				worker.frames.push(outcome.value)
+				interpreter.heap.activate(worker)
+				defer interpreter.heap.deactivate(worker)
+				result := callback.Call(worker, []interface{}{outcome.value})
+				if !worker.hadRuntimeError {
+					derived.resolve(result)
+				}
+			}, callback, derived)
+			return derived, nil
+		})
+	}
+	return nil
+}
+
+// coroutine connects the event loop to the goroutine running an async function's
+// body. Exactly one side runs at a time: the loop sends on
+// resume and waits on events, the body sends the promise it is awaiting (or nil
+// once it finishes) and waits on resume.
+type coroutine struct {
+	resume chan promiseOutcome
+	events chan *LoxPromise
+}
+
+// callAsync starts an async function's body and returns the promise for its result.
+// The body runs synchronously up to its first await, like a regular call.
+func (i *Interpreter) callAsync(function *LoxFunction, environment *Environment) *LoxPromise {
+	promise := newLoxPromise(i.loop)
+	co := &coroutine{
+		resume: make(chan promiseOutcome),
+		events: make(chan *LoxPromise),
+	}
+	body := i.fork(environment)
+	body.coroutine = co
+	body.errorSink = promise
+	i.heap.activate(body)
+
+	go func() {
+		<-co.resume
+
+		var result interface{}
+		defer func() {
+			i.heap.deactivate(body)
+			if r := recover(); r != nil {
+				body.internalError(r)
+			}
+			if !body.hadRuntimeError {
+				promise.resolve(result)
+			}
+			co.events <- nil
+		}()
+
+		completion := body.executeBlock(function.declaration.Body, environment)
+		if completion != nil && completion.kind == completeReturn {
+			result = completion.value
+		}
+	}()
+
+	i.loop.drive(co, promiseOutcome{})
+	return promise
+}
+
+// VisitAwaitExpr waits for a promise to settle. Inside an async function the body is
+// parked until the event loop resumes it; at the top level the event loop runs
+// until the promise settles. Values that aren't promises are returned as is.
+func (i *Interpreter) VisitAwaitExpr(expr *Await) interface{} {
+	value := i.Evaluate(expr.Value)
+	if i.hadRuntimeError {
+		return nil
+	}
+
+	promise, ok := value.(*LoxPromise)
+	if !ok {
+		return value
+	}
+	i.frames.push(promise)
+	defer i.frames.pop()
+
+	var outcome promiseOutcome
+	if i.coroutine != nil {
+		i.coroutine.events <- promise
+		outcome = <-i.coroutine.resume
+	} else {
+		promise.onSettle(func(promiseOutcome) {})
+		i.runEventLoop(promise.isSettled)
+		if i.hadRuntimeError {
+			return nil
+		}
+		if !promise.isSettled() {
+			i.runtimeError(expr.Keyword, "Awaited promise can never settle.")
+			return nil
+		}
+		outcome = promise.outcome
+	}
+
+	// A rejection is raised again where it is awaited, keeping its original line
+	if outcome.rejected {
+		i.runtimeError(Token{Line: outcome.line}, outcome.message)
+		return nil
+	}
+	return outcome.value
+}
+
+// loopTimer is a callback scheduled by setTimeout, setInterval or delay
+type loopTimer struct {
+	id       int
+	due      time.Time
+	interval time.Duration // zero for one-shot timers
+	run      func(*Interpreter)
+	retains  []interface{} // values run uses, which stay reachable while it's scheduled
+}
+
+// loopJob is work queued on the event loop
+type loopJob struct {
+	run     func(*Interpreter)
+	retains []interface{} // values run uses, which stay reachable until it has run
+}
+
+// eventLoop holds the work queued for the interpreter: jobs that are ready to run,
+// such as resuming an async function, and timers waiting for their due time. It is
+// shared by every interpreter forked from the same root.
+type eventLoop struct {
+	mu       sync.Mutex
+	jobs     []loopJob
+	timers   []*loopTimer
+	nextID   int
+	rejected []*LoxPromise
+}
+
+func newEventLoop() *eventLoop {
+	return &eventLoop{}
+}
+
+// enqueue adds a job to run on the next turn of the loop
+func (l *eventLoop) enqueue(job func(*Interpreter), retains ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jobs = append(l.jobs, loopJob{run: job, retains: retains})
+}
+
+// roots returns the values the queued jobs and scheduled timers retain
+func (l *eventLoop) roots() []interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var roots []interface{}
+	for _, job := range l.jobs {
+		roots = append(roots, job.retains...)
+	}
+	for _, timer := range l.timers {
+		roots = append(roots, timer.retains...)
+	}
+	return roots
+}
+
+// trackRejection remembers a rejected promise so it can be reported if nothing
+// ever handles it
+func (l *eventLoop) trackRejection(promise *LoxPromise) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rejected = append(l.rejected, promise)
+}
+
+// addTimer schedules run after delay, repeating every interval if it's non-zero,
+// and returns the timer's id
+func (l *eventLoop) addTimer(clock Clock, delay, interval time.Duration, run func(*Interpreter), retains ...interface{}) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	l.timers = append(l.timers, &loopTimer{
+		id:       l.nextID,
+		due:      clock.Now().Add(delay),
+		interval: interval,
+		run:      run,
+		retains:  retains,
+	})
+	return l.nextID
+}
+
+// cancelTimer removes a timer, reporting whether it was still scheduled
+func (l *eventLoop) cancelTimer(id int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for index, timer := range l.timers {
+		if timer.id == id {
+			l.timers = append(l.timers[:index], l.timers[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the next job to run, sleeping until the earliest timer is due if no
+// job is ready. It returns nil once there is nothing left to do.
+func (l *eventLoop) next(clock Clock) func(*Interpreter) {
+	l.mu.Lock()
+	if len(l.jobs) > 0 {
+		job := l.jobs[0]
+		l.jobs = l.jobs[1:]
+		l.mu.Unlock()
+		return job.run
+	}
+	if len(l.timers) == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	// Timers due at the same time fire in the order they were scheduled
+	earliest := 0
+	for index, timer := range l.timers {
+		if timer.due.Before(l.timers[earliest].due) {
+			earliest = index
+		}
+	}
+	timer := l.timers[earliest]
+	due := timer.due
+	if timer.interval > 0 {
+		timer.due = timer.due.Add(timer.interval)
+	} else {
+		l.timers = append(l.timers[:earliest], l.timers[earliest+1:]...)
+	}
+	l.mu.Unlock()
+
+	if wait := due.Sub(clock.Now()); wait > 0 {
+		clock.Sleep(wait)
+	}
+	return timer.run
+}
+
+// drive resumes a coroutine and, if it stops at an await, arranges for it to be
+// resumed again once the awaited promise settles
+func (l *eventLoop) drive(co *coroutine, outcome promiseOutcome) {
+	co.resume <- outcome
+	awaited := <-co.events
+	if awaited == nil {
+		return
+	}
+	awaited.onSettle(func(outcome promiseOutcome) {
+		l.drive(co, outcome)
+	})
+}
+
+// runEventLoop runs queued jobs and timers until until reports true, the loop runs
+// out of work, or a runtime error occurs. A nil until runs the loop dry.
+func (i *Interpreter) runEventLoop(until func() bool) {
+	for !i.hadRuntimeError && !i.exit.requested.Load() && (until == nil || !until()) {
+		i.runFinalizers()
+		job := i.loop.next(i.options.Clock)
+		if job == nil {
+			return
+		}
+		job(i)
+	}
+}
+
+// RunEventLoop drains the event loop once the script's top-level code has finished,
+// then reports any promise that was rejected without being awaited or handled
+func (i *Interpreter) RunEventLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			i.internalError(r)
+		}
+	}()
+
+	i.runEventLoop(nil)
+	if i.hadRuntimeError || i.exit.requested.Load() {
+		return
+	}
+
+	i.loop.mu.Lock()
+	rejected := i.loop.rejected
+	i.loop.rejected = nil
+	i.loop.mu.Unlock()
+
+	for _, promise := range rejected {
+		promise.mu.Lock()
+		handled, outcome := promise.handled, promise.outcome
+		promise.mu.Unlock()
+		if !handled {
+			i.runtimeError(Token{Line: outcome.line}, "Unhandled promise rejection: "+outcome.message)
+		}
+	}
+}
+
+// timerCallbackArg returns the argument at index as a function taking no arguments
+func timerCallbackArg(name string, arguments []interface{}, index int) (LoxCallable, error) {
+	callback, ok := arguments[index].(LoxCallable)
+	if !ok || (callback.Arity() >= 0 && callback.Arity() != 0) {
+		return nil, fmt.Errorf("%s() expects a function with no parameters.", name)
+	}
+	return callback, nil
+}
+
+// delayArg returns the argument at index as a non-negative number of milliseconds
+func (i *Interpreter) delayArg(name string, arguments []interface{}, index int) (time.Duration, error) {
+	ms, err := i.numberArg(name, arguments, index)
+	if err != nil {
+		return 0, err
+	}
+	if ms < 0 {
+		return 0, fmt.Errorf("Delay can't be negative.")
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// defineAsyncNatives registers the timer natives in the given environment. Timer
+// callbacks run on the event loop, after the top-level code has finished or while
+// it is awaiting a promise.
+func defineAsyncNatives(env *Environment) {
+	env.Define("setTimeout", NewNativeFunction("setTimeout", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		callback, err := timerCallbackArg("setTimeout", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		delay, err := interpreter.delayArg("setTimeout", arguments, 1)
+		if err != nil {
+			return nil, err
+		}
+		id := interpreter.loop.addTimer(interpreter.options.Clock, delay, 0, func(runner *Interpreter) {
+			callback.Call(runner, nil)
+		}, callback)
+		return float64(id), nil
+	}))
+
+	env.Define("setInterval", NewNativeFunction("setInterval", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		callback, err := timerCallbackArg("setInterval", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		interval, err := interpreter.delayArg("setInterval", arguments, 1)
+		if err != nil {
+			return nil, err
+		}
+		if interval == 0 {
+			return nil, fmt.Errorf("Interval must be greater than zero.")
+		}
+		id := interpreter.loop.addTimer(interpreter.options.Clock, interval, interval, func(runner *Interpreter) {
+			callback.Call(runner, nil)
+		}, callback)
+		return float64(id), nil
+	}))
+
+	env.Define("clearTimer", NewNativeFunction("clearTimer", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		id, err := interpreter.integerArg("clearTimer", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		return interpreter.loop.cancelTimer(id), nil
+	}))
+
+	// delay(ms) returns a promise that is fulfilled with nil after ms milliseconds
+	env.Define("delay", NewNativeFunction("delay", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		delay, err := interpreter.delayArg("delay", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		promise := newLoxPromise(interpreter.loop)
+		interpreter.loop.addTimer(interpreter.options.Clock, delay, 0, func(*Interpreter) {
+			promise.resolve(nil)
+		}, promise)
+		return promise, nil
+	}))
+}
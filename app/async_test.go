@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAsyncAwaitAndTimers(t *testing.T) {
+	source := `
+async fun add(a, b) {
+  await delay(10);
+  return a + b;
+}
+async fun twice(x) {
+  var y = await add(x, x);
+  print "twice " + str(y);
+  return y;
+}
+print "start";
+var p = twice(2);
+print p;
+fun five() { print "timeout 5"; }
+setTimeout(five, 5);
+var n = 0;
+var id;
+fun tick() {
+  n = n + 1;
+  print "tick " + str(n);
+  if (n == 3) clearTimer(id);
+}
+id = setInterval(tick, 4);
+print await p;
+print p;
+fun show(v) { print "then " + str(v); }
+add(1, 2).then(show);
+class A {
+  init(x) { this.x = x; }
+  async get() { await delay(1); return this.x; }
+}
+print await A(7).get();
+print await 5;
+print "end";
+`
+	// The fake clock makes timers fire in due order without waiting
+	clock := NewFakeClock(time.Unix(0, 0))
+	want := "start\n<promise pending>\ntick 1\ntimeout 5\ntick 2\ntwice 4\n4\n<promise fulfilled>\n7\n5\nend\ntick 3\nthen 3\n"
+	if got := runScript(t, source, Options{Clock: clock}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestAsyncErrors(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	printed, errors := runFailingScript(t, `
+async fun boom() {
+  await delay(1);
+  return nil + 1;
+}
+async fun caller() {
+  return await boom();
+}
+caller();
+print "after";
+`, Options{Clock: clock})
+	if want := "Unhandled promise rejection: Operands must be two numbers or two strings.\n[line 4]\n"; printed != "after\n" || errors != want {
+		t.Errorf("got output %q and error %q, want %q and %q", printed, errors, "after\n", want)
+	}
+
+	// A rejection is raised again where it's awaited, with its original line
+	printed, errors = runFailingScript(t, `
+async fun f() { return 1 + nil; }
+print "a";
+await f();
+print "b";
+`, Options{})
+	if want := "Operands must be two numbers or two strings.\n[line 2]\n"; printed != "a\n" || errors != want {
+		t.Errorf("got output %q and error %q, want %q and %q", printed, errors, "a\n", want)
+	}
+
+	runtime := []struct {
+		source string
+		want   string
+	}{
+		{"var p;\nasync fun f() { await delay(1); return await p; }\np = f();\nawait p;", "Awaited promise can never settle.\n[line 4]\n"},
+		{"fun g(a, b) {}\ndelay(1).then(g);", "then() expects a function with one parameter.\n[line 2]\n"},
+		{"setTimeout(1, 1);", "setTimeout() expects a function with no parameters.\n[line 1]\n"},
+		{"setTimeout(clock, -1);", "Delay can't be negative.\n[line 1]\n"},
+		{"setInterval(clock, 0);", "Interval must be greater than zero.\n[line 1]\n"},
+	}
+	for _, test := range runtime {
+		if _, errors := runFailingScript(t, test.source, Options{Clock: NewFakeClock(time.Unix(0, 0))}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+
+	compile := []struct {
+		source string
+		want   string
+	}{
+		{"fun f() { await 1; }", "[line 1] Error at 'await': Can't use 'await' outside of an async function.\n"},
+		{"class A { async init() {} }", "[line 1] Error at 'init': An initializer can't be async.\n"},
+	}
+	for _, test := range compile {
+		if errors := compileErrors(t, test.source); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
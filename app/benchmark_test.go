@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// runBenchmark times the program in benchmarks/ with the given name, which prints
+// its result and its own timing; the output is discarded
+func runBenchmark(b *testing.B, name string) {
+	source, err := os.ReadFile("../benchmarks/" + name + ".lox")
+	if err != nil {
+		b.Fatal(err)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+
+	for b.Loop() {
+		scanner := NewScanner(string(source))
+		scanner.AllowExtensions()
+		parser := NewParser(scanner.ScanTokens())
+		statements := parser.ParseStatements()
+		interpreter := NewInterpreter()
+		NewResolver(interpreter).Resolve(statements)
+		interpreter.InterpretStatements(NewOptimizer(interpreter).Optimize(statements))
+		if interpreter.HasRuntimeError() {
+			b.Fatalf("%s failed at runtime", name)
+		}
+	}
+}
+
+func BenchmarkFib(b *testing.B)     { runBenchmark(b, "fib") }
+func BenchmarkLoop(b *testing.B)    { runBenchmark(b, "loop") }
+func BenchmarkMethods(b *testing.B) { runBenchmark(b, "methods") }
+func BenchmarkStrings(b *testing.B) { runBenchmark(b, "strings") }
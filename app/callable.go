@@ -2,14 +2,10 @@ package main
 
 import (
 	"fmt"
-	"time"
+	"math"
+	"sync"
 )
 
-// ReturnValue is used to propagate return values up the call stack
-type ReturnValue struct {
-	Value interface{}
-}
-
 // LoxCallable is the interface for all callable objects (functions, native functions, etc.)
 type LoxCallable interface {
 	Arity() int
@@ -24,8 +20,102 @@ func (c *ClockNative) Arity() int {
 }
 
 func (c *ClockNative) Call(interpreter *Interpreter, arguments []interface{}) interface{} {
-	// Return Unix timestamp as float64
-	return float64(time.Now().Unix())
+	// Return Unix timestamp in fractional seconds
+	return unixSeconds(interpreter.options.Clock.Now())
+}
+
+func (c *ClockNative) String() string {
+	return "<native fn>"
+}
+
+// NativeFunction wraps a Go function so it can be called from Lox code
+type NativeFunction struct {
+	name     string
+	arity    int
+	fn       func(interpreter *Interpreter, arguments []interface{}) (interface{}, error)
+	receiver interface{} // the value a built-in method is bound to
+}
+
+func NewNativeFunction(name string, arity int, fn func(interpreter *Interpreter, arguments []interface{}) (interface{}, error)) *NativeFunction {
+	return &NativeFunction{
+		name:  name,
+		arity: arity,
+		fn:    fn,
+	}
+}
+
+func (n *NativeFunction) Arity() int {
+	return n.arity
+}
+
+// Call runs the wrapped Go function, turning a returned error into a runtime error
+// reported at the call site
+func (n *NativeFunction) Call(interpreter *Interpreter, arguments []interface{}) interface{} {
+	token := interpreter.callToken
+	value, err := n.fn(interpreter, arguments)
+	if exit, ok := err.(*exitRequest); ok {
+		interpreter.exit.request(exit.code)
+		interpreter.hadRuntimeError = true
+		return nil
+	}
+	if err != nil {
+		interpreter.runtimeError(token, err.Error())
+		return nil
+	}
+	return value
+}
+
+func (n *NativeFunction) String() string {
+	return "<native fn>"
+}
+
+// numberArg returns the argument at index as a number, or an error naming the native
+func (i *Interpreter) numberArg(name string, arguments []interface{}, index int) (float64, error) {
+	if !i.isNumber(arguments[index]) {
+		return 0, fmt.Errorf("Argument %d to '%s' must be a number.", index+1, name)
+	}
+	return i.toNumber(arguments[index]), nil
+}
+
+// maxExactInteger is the largest magnitude up to which every whole number is exact
+// in a float64
+const maxExactInteger = 1 << 53
+
+// integerArg returns the argument at index as a whole number. Infinities and values
+// too large to be exact are rejected rather than wrapping around when converted.
+func (i *Interpreter) integerArg(name string, arguments []interface{}, index int) (int, error) {
+	num, err := i.numberArg(name, arguments, index)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsInf(num, 0) || math.IsNaN(num) {
+		return 0, fmt.Errorf("Argument %d to '%s' must be a finite number.", index+1, name)
+	}
+	if num != math.Trunc(num) {
+		return 0, fmt.Errorf("Argument %d to '%s' must be a whole number.", index+1, name)
+	}
+	if math.Abs(num) > maxExactInteger {
+		return 0, fmt.Errorf("Argument %d to '%s' must be between -2^53 and 2^53.", index+1, name)
+	}
+	return int(num), nil
+}
+
+// listArg returns the argument at index as a list, or an error naming the native
+func (i *Interpreter) listArg(name string, arguments []interface{}, index int) (*LoxList, error) {
+	list, ok := arguments[index].(*LoxList)
+	if !ok {
+		return nil, fmt.Errorf("Argument %d to '%s' must be a list.", index+1, name)
+	}
+	return list, nil
+}
+
+// stringArg returns the argument at index as a string, or an error naming the native
+func (i *Interpreter) stringArg(name string, arguments []interface{}, index int) (string, error) {
+	str, ok := arguments[index].(string)
+	if !ok {
+		return "", fmt.Errorf("Argument %d to '%s' must be a string.", index+1, name)
+	}
+	return str, nil
 }
 
 // LoxFunction represents a user-defined function
@@ -48,39 +138,71 @@ func (f *LoxFunction) Arity() int {
 }
 
 func (f *LoxFunction) Call(interpreter *Interpreter, arguments []interface{}) interface{} {
-	// Create a new environment for the function execution
-	// Use the closure environment as the parent, not the current environment
-	environment := NewEnclosedEnvironment(f.closure)
-
-	// Bind parameters to arguments
-	for i, param := range f.declaration.Params {
-		environment.Define(param.Lexeme, arguments[i])
-	}
-
-	// Use defer/recover to catch return values
-	var returnValue interface{}
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				if ret, ok := r.(*ReturnValue); ok {
-					returnValue = ret.Value
-				} else {
-					// Re-panic if it's not a return value
-					panic(r)
-				}
-			}
-		}()
+	return f.call(interpreter, f.closure, arguments)
+}
 
-		// Execute the function body
-		interpreter.executeBlock(f.declaration.Body, environment)
-	}()
+// invoke calls the function as a method of instance. It binds "this" the same way
+// Bind does but without allocating a bound function, for calls like obj.method().
+func (f *LoxFunction) invoke(interpreter *Interpreter, instance *LoxInstance, arguments []interface{}) interface{} {
+	return f.call(interpreter, f.thisEnvironment(instance), arguments)
+}
 
-	// If this is an initializer, always return "this" instead of the return value
-	if f.isInitializer {
-		return f.closure.GetAt(0, "this")
-	}
+// thisEnvironment returns an environment binding "this" to instance, enclosed by
+// the function's closure
+func (f *LoxFunction) thisEnvironment(instance *LoxInstance) *Environment {
+	environment := newLocalEnvironment(f.closure, 1)
+	environment.Define("this", instance)
+	return environment
+}
 
-	return returnValue
+// call runs the function with the given environment as the parent of its body.
+// When the body ends in a tail call to another Lox function, that function is run
+// by the same loop instead of a nested call, so tail-recursive code, including
+// mutually recursive functions, runs in constant Go stack space.
+func (f *LoxFunction) call(interpreter *Interpreter, closure *Environment, arguments []interface{}) interface{} {
+	for {
+		// Create a new environment for the function execution
+		// Use the closure environment as the parent, not the current environment
+		environment := newLocalEnvironment(closure, len(f.declaration.Params))
+
+		// Bind parameters to arguments
+		for i, param := range f.declaration.Params {
+			environment.Define(param.Lexeme, arguments[i])
+		}
+
+		// Generators run their body lazily as values are requested
+		if f.declaration.IsGenerator {
+			return NewLoxGenerator(interpreter, f, environment)
+		}
+
+		// Async functions return a promise for the body's result
+		if f.declaration.IsAsync {
+			return interpreter.callAsync(f, environment)
+		}
+
+		// Execute the function body
+		result := interpreter.executeBlock(f.declaration.Body, environment)
+
+		// If this is an initializer, always return "this" instead of the return value
+		if f.isInitializer {
+			return closure.GetAt(0, 0) // "this" is the only slot of a bound method's closure
+		}
+
+		if result == nil {
+			return nil
+		}
+		switch result.kind {
+		case completeReturn:
+			return result.value
+		case completeTailCall:
+			f, closure, arguments = result.function, result.function.closure, result.arguments
+			if result.this != nil {
+				closure = f.thisEnvironment(result.this)
+			}
+			continue
+		}
+		return nil
+	}
 }
 
 func (f *LoxFunction) String() string {
@@ -90,39 +212,127 @@ func (f *LoxFunction) String() string {
 // Bind creates a bound method with a specific instance as "this"
 func (f *LoxFunction) Bind(instance *LoxInstance) *LoxFunction {
 	// Create a new environment with "this" bound to the instance
-	environment := NewEnclosedEnvironment(f.closure)
-	environment.Define("this", instance)
-	bound := NewLoxFunction(f.declaration, environment)
+	bound := NewLoxFunction(f.declaration, f.thisEnvironment(instance))
 	bound.isInitializer = f.isInitializer
 	return bound
 }
 
 // LoxClass represents a user-defined class
 type LoxClass struct {
-	name       string
-	superclass *LoxClass
-	methods    map[string]*LoxFunction
+	name          string
+	superclass    *LoxClass
+	methods       map[string]*LoxFunction // declared by this class
+	methodTable   map[string]*LoxFunction // declared and inherited, for lookups
+	staticMethods map[string]*LoxFunction
+	getters       map[string]*LoxFunction
+	setters       map[string]*LoxFunction
+	declaration   *Class
+	closure       *Environment // environment field initializers are evaluated in
 }
 
 func NewLoxClass(name string, superclass *LoxClass, methods map[string]*LoxFunction) *LoxClass {
+	// Classes can't change once created, so the inherited methods are flattened into
+	// one table up front instead of walking the superclass chain on every lookup
+	methodTable := make(map[string]*LoxFunction)
+	if superclass != nil {
+		for methodName, method := range superclass.methodTable {
+			methodTable[methodName] = method
+		}
+	}
+	for methodName, method := range methods {
+		methodTable[methodName] = method
+	}
+
 	return &LoxClass{
-		name:       name,
-		superclass: superclass,
-		methods:    methods,
+		name:          name,
+		superclass:    superclass,
+		methods:       methods,
+		methodTable:   methodTable,
+		staticMethods: make(map[string]*LoxFunction),
+		getters:       make(map[string]*LoxFunction),
+		setters:       make(map[string]*LoxFunction),
 	}
 }
 
-// FindMethod looks up a method by name
+// FindMethod looks up a method by name, including inherited ones
 func (c *LoxClass) FindMethod(name string) *LoxFunction {
-	if method, ok := c.methods[name]; ok {
-		return method
+	return c.methodTable[name]
+}
+
+// HasDeclaredFields reports whether this class or any superclass declares fields.
+// Instances of such classes are sealed: only declared fields can be assigned.
+func (c *LoxClass) HasDeclaredFields() bool {
+	for class := c; class != nil; class = class.superclass {
+		if class.declaration != nil && len(class.declaration.Fields) > 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	// Check superclass if method not found
-	if c.superclass != nil {
-		return c.superclass.FindMethod(name)
+// DeclaresField reports whether this class or any superclass declares the field
+func (c *LoxClass) DeclaresField(name string) bool {
+	for class := c; class != nil; class = class.superclass {
+		if class.declaration == nil {
+			continue
+		}
+		for _, field := range class.declaration.Fields {
+			if field.Name.Lexeme == name {
+				return true
+			}
+		}
 	}
+	return false
+}
 
+// IsSubclassOf reports whether this class is other or inherits from it
+func (c *LoxClass) IsSubclassOf(other *LoxClass) bool {
+	for class := c; class != nil; class = class.superclass {
+		if class == other {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestor returns the class in this class's chain created from the given
+// declaration, or nil if there is none
+func (c *LoxClass) ancestor(declaration *Class) *LoxClass {
+	for class := c; class != nil; class = class.superclass {
+		if class.declaration == declaration {
+			return class
+		}
+	}
+	return nil
+}
+
+// FindStaticMethod looks up a static method by name, including inherited ones
+func (c *LoxClass) FindStaticMethod(name string) *LoxFunction {
+	for class := c; class != nil; class = class.superclass {
+		if method, ok := class.staticMethods[name]; ok {
+			return method
+		}
+	}
+	return nil
+}
+
+// FindGetter looks up a getter by name, including inherited ones
+func (c *LoxClass) FindGetter(name string) *LoxFunction {
+	for class := c; class != nil; class = class.superclass {
+		if getter, ok := class.getters[name]; ok {
+			return getter
+		}
+	}
+	return nil
+}
+
+// FindSetter looks up a setter by name, including inherited ones
+func (c *LoxClass) FindSetter(name string) *LoxFunction {
+	for class := c; class != nil; class = class.superclass {
+		if setter, ok := class.setters[name]; ok {
+			return setter
+		}
+	}
 	return nil
 }
 
@@ -142,6 +352,12 @@ func (c *LoxClass) Arity() int {
 // Call creates a new instance of the class
 func (c *LoxClass) Call(interpreter *Interpreter, arguments []interface{}) interface{} {
 	instance := NewLoxInstance(c)
+	interpreter.heap.trackInstance(instance)
+
+	// Initialize declared fields, superclass fields first
+	if !c.initializeFields(interpreter, instance) {
+		return nil
+	}
 
 	// Call the init method if it exists
 	initializer := c.FindMethod("init")
@@ -152,10 +368,59 @@ func (c *LoxClass) Call(interpreter *Interpreter, arguments []interface{}) inter
 	return instance
 }
 
-// LoxInstance represents an instance of a class
+// initializeFields evaluates the default initializers declared by the class and its
+// superclasses, with "this" bound to the new instance. Returns false on a runtime error.
+func (c *LoxClass) initializeFields(interpreter *Interpreter, instance *LoxInstance) bool {
+	if c.superclass != nil && !c.superclass.initializeFields(interpreter, instance) {
+		return false
+	}
+	if c.declaration == nil || len(c.declaration.Fields) == 0 {
+		return true
+	}
+
+	environment := NewEnclosedEnvironment(c.closure)
+	environment.Define("this", instance)
+	for _, field := range c.declaration.Fields {
+		var value interface{}
+		if field.Initializer != nil {
+			value = interpreter.evaluateIn(field.Initializer, environment)
+			if interpreter.hadRuntimeError {
+				return false
+			}
+		}
+		if isPrivateName(field.Name) {
+			instance.setPrivateField(c, field.Name.Lexeme, value)
+		} else {
+			instance.setField(field.Name.Lexeme, value)
+		}
+	}
+	return true
+}
+
+// LoxTrait represents a trait, whose methods are copied into the classes that use it
+type LoxTrait struct {
+	name    string
+	methods map[string]*LoxFunction
+}
+
+func NewLoxTrait(name string, methods map[string]*LoxFunction) *LoxTrait {
+	return &LoxTrait{
+		name:    name,
+		methods: methods,
+	}
+}
+
+func (t *LoxTrait) String() string {
+	return fmt.Sprintf("<trait %s>", t.name)
+}
+
+// LoxInstance represents an instance of a class. Fields are guarded by a lock so
+// instances can be shared between concurrent tasks.
 type LoxInstance struct {
-	class  *LoxClass
-	fields map[string]interface{}
+	mu      sync.RWMutex
+	class   *LoxClass
+	fields  map[string]interface{}
+	private map[*LoxClass]map[string]interface{} // private fields by declaring class
 }
 
 func NewLoxInstance(class *LoxClass) *LoxInstance {
@@ -169,24 +434,95 @@ func (i *LoxInstance) String() string {
 	return fmt.Sprintf("%s instance", i.class.name)
 }
 
-// Get retrieves a property or method from the instance
-func (i *LoxInstance) Get(name Token) interface{} {
+// Get retrieves a property or method from the instance, reporting whether it exists.
+// Getters are run immediately and their result returned.
+func (i *LoxInstance) Get(interpreter *Interpreter, name Token) (interface{}, bool) {
 	// First check for fields
-	if value, ok := i.fields[name.Lexeme]; ok {
-		return value
+	if value, ok := i.field(name.Lexeme); ok {
+		return value, true
+	}
+
+	// Then check for getters
+	if getter := i.class.FindGetter(name.Lexeme); getter != nil {
+		return getter.Bind(i).Call(interpreter, nil), true
 	}
 
 	// Then check for methods
 	method := i.class.FindMethod(name.Lexeme)
 	if method != nil {
-		return method.Bind(i)
+		return method.Bind(i), true
 	}
 
 	// Property doesn't exist - this will be handled by the interpreter
-	return nil
+	return nil, false
 }
 
 // Set sets a property on the instance
 func (i *LoxInstance) Set(name Token, value interface{}) {
-	i.fields[name.Lexeme] = value
+	i.setField(name.Lexeme, value)
+}
+
+// field returns the value of a field, reporting whether it exists
+func (i *LoxInstance) field(name string) (interface{}, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	value, ok := i.fields[name]
+	return value, ok
+}
+
+// setField stores a field value
+func (i *LoxInstance) setField(name string, value interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.fields[name] = value
+}
+
+// privateField returns the value of a private field declared by owner, reporting
+// whether it exists. Each class has its own private fields, so a subclass declaring
+// a private field with the same name doesn't replace its superclass's.
+func (i *LoxInstance) privateField(owner *LoxClass, name string) (interface{}, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	value, ok := i.private[owner][name]
+	return value, ok
+}
+
+// setPrivateField stores the value of a private field declared by owner
+func (i *LoxInstance) setPrivateField(owner *LoxClass, name string, value interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.private == nil {
+		i.private = make(map[*LoxClass]map[string]interface{})
+	}
+	fields, ok := i.private[owner]
+	if !ok {
+		fields = make(map[string]interface{})
+		i.private[owner] = fields
+	}
+	fields[name] = value
+}
+
+// privateSnapshot returns a copy of the instance's private fields
+func (i *LoxInstance) privateSnapshot() map[*LoxClass]map[string]interface{} {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	private := make(map[*LoxClass]map[string]interface{}, len(i.private))
+	for owner, fields := range i.private {
+		private[owner] = make(map[string]interface{}, len(fields))
+		for name, value := range fields {
+			private[owner][name] = value
+		}
+	}
+	return private
+}
+
+// fieldSnapshot returns a copy of the instance's public fields
+func (i *LoxInstance) fieldSnapshot() map[string]interface{} {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	fields := make(map[string]interface{}, len(i.fields))
+	for name, value := range i.fields {
+		fields[name] = value
+	}
+	return fields
 }
@@ -0,0 +1,132 @@
+package main
+
+import "testing"
+
+func TestPrivateFieldsBelongToDeclaringClass(t *testing.T) {
+	source := `
+class A { var #x = 1; get() { return this.#x; } setA(v) { this.#x = v; } }
+class B < A { var #x = 2; getB() { return this.#x; } }
+var b = B();
+print b.get();
+print b.getB();
+b.setA(10);
+print b.get();
+print b.getB();
+print fields(b);
+`
+	want := "1\n2\n10\n2\n[]\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestStaticMethodsGettersAndSetters(t *testing.T) {
+	source := `
+class Shape {
+  init(w, h) { this.w = w; this.h = h; }
+  area { return this.w * this.h; }
+  set width(v) { this.w = v; }
+  class square(n) { return Shape(n, n); }
+  set(x) { return x; }
+}
+var s = Shape.square(3);
+print s.area;
+s.width = 10;
+print s.area;
+print s.set(5);
+class Sub < Shape {}
+print Sub.square(2).area;
+s.area = 1;
+print s.area;
+`
+	// A field assigned over a getter shadows it, as fields shadow methods
+	want := "9\n30\n5\n4\n1\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestClassMemberErrors(t *testing.T) {
+	runtime := []struct {
+		source string
+		want   string
+	}{
+		{"class A { class m() {} }\nA().m();", "Undefined property 'm'.\n[line 2]\n"},
+		{"class A { m() {} }\nA.m();", "Undefined property 'm'.\n[line 2]\n"},
+		{"class A { set w(v) { this.v = v; } }\nprint A().w;", "Undefined property 'w'.\n[line 2]\n"},
+	}
+	for _, test := range runtime {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+
+	compile := []struct {
+		source string
+		want   string
+	}{
+		{"class A { class f() { return this; } }", "[line 1] Error at 'this': Can't use 'this' in a static method.\n"},
+		{"class A { class g() { fun h() { return this; } } }", "[line 1] Error at 'this': Can't use 'this' in a static method.\n"},
+		{"class A { set w(a, b) {} }", "[line 1] Error at 'w': A setter must have exactly one parameter.\n"},
+	}
+	for _, test := range compile {
+		if errors := compileErrors(t, test.source); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
+
+func TestMethodCallsSeeEachReceiver(t *testing.T) {
+	source := `
+class A { name() { return "A"; } who() { return this.name(); } }
+class B < A { name() { return "B"; } }
+fun call(o) { return o.who(); }
+print call(A());
+print call(B());
+print call(A());
+class M { m() { return this.v; } }
+var a = M(); a.v = 1;
+var b = M(); b.v = 2;
+var bound = a.m;
+print bound();
+print b.m();
+a.v = 3;
+print bound();
+a.m = 5;
+print a.m;
+print b.m();
+fun f() { return "field fn"; }
+b.m = f;
+print b.m();
+class S1 { m() { return "1"; } }
+class S2 < S1 { m() { return super.m() + "2"; } }
+class S3 < S2 {}
+fun run(o) { return o.m(); }
+print run(S3());
+print run(S1());
+class Init { init(x) { this.x = x; } }
+print Init(1).init(5).x;
+`
+	// The same call site sees different classes, and a field shadows a method
+	// from the moment it's set
+	want := "A\nB\nA\n1\n2\n3\n5\n2\nfield fn\n12\n1\n5\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestMethodCallErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"class A {}\nA().missing();", "Undefined property 'missing'.\n[line 2]\n"},
+		{"class A { m() { return 1; } }\nvar a = A();\na.m = 5;\na.m();", "Can only call functions and classes.\n[line 4]\n"},
+		{"class A { m(x) {} }\nA().m();", "Expected 1 arguments but got 0.\n[line 2]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defineCollectionNatives registers the collection constructors in the given environment
+func defineCollectionNatives(env *Environment) {
+	env.Define("list", NewNativeFunction("list", -1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		elements := make([]interface{}, len(arguments))
+		copy(elements, arguments)
+		return NewLoxList(elements), nil
+	}))
+
+	env.Define("map", NewNativeFunction("map", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return NewLoxMap(), nil
+	}))
+
+	env.Define("range", NewNativeFunction("range", -1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		if len(arguments) < 1 || len(arguments) > 3 {
+			return nil, fmt.Errorf("Expected 1 to 3 arguments but got %d.", len(arguments))
+		}
+		bounds := []float64{0, 0, 1}
+		for index := range arguments {
+			num, err := interpreter.numberArg("range", arguments, index)
+			if err != nil {
+				return nil, err
+			}
+			// A NaN or infinite bound or step would never let a loop finish
+			if math.IsNaN(num) || math.IsInf(num, 0) {
+				return nil, fmt.Errorf("Argument %d to 'range' must be a finite number.", index+1)
+			}
+			bounds[index] = num
+		}
+		// range(end) counts from zero
+		if len(arguments) == 1 {
+			bounds[0], bounds[1] = 0, bounds[0]
+		}
+		if bounds[2] == 0 {
+			return nil, fmt.Errorf("Range step can't be zero.")
+		}
+		if bounds[0]+bounds[2] == bounds[0] {
+			return nil, fmt.Errorf("Range step is too small to move past the start.")
+		}
+		return NewLoxRange(bounds[0], bounds[1], bounds[2]), nil
+	}))
+}
+
+// LoxList is a growable, ordered list of Lox values. Lists can be shared by
+// concurrent tasks, so access to the elements is guarded by a lock.
+type LoxList struct {
+	mu       sync.RWMutex
+	elements []interface{}
+}
+
+func NewLoxList(elements []interface{}) *LoxList {
+	if elements == nil {
+		elements = []interface{}{}
+	}
+	return &LoxList{elements: elements}
+}
+
+// iteratorFunc returns a function producing the list's elements in order. Elements
+// appended during iteration are visited too.
+func (l *LoxList) iteratorFunc() func() (interface{}, bool) {
+	index := 0
+	return func() (interface{}, bool) {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		if index >= len(l.elements) {
+			return nil, false
+		}
+		index++
+		return l.elements[index-1], true
+	}
+}
+
+// snapshot returns a copy of the elements, for reading them without holding the lock
+func (l *LoxList) snapshot() []interface{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]interface{}(nil), l.elements...)
+}
+
+// Len returns the number of elements
+func (l *LoxList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.elements)
+}
+
+// Get returns the element at index, or an error if it's out of range
+func (l *LoxList) Get(index int) (interface{}, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if index < 0 || index >= len(l.elements) {
+		return nil, fmt.Errorf("List index %d out of range.", index)
+	}
+	return l.elements[index], nil
+}
+
+// listMethod returns the built-in method with the given name bound to the list, or nil
+func listMethod(list *LoxList, name string) *NativeFunction {
+	switch name {
+	case "len":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return float64(list.Len()), nil
+		})
+	case "get":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			index, err := interpreter.integerArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			return list.Get(index)
+		})
+	case "set":
+		return NewNativeFunction(name, 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			index, err := interpreter.integerArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			list.mu.Lock()
+			defer list.mu.Unlock()
+			if index < 0 || index >= len(list.elements) {
+				return nil, fmt.Errorf("List index %d out of range.", index)
+			}
+			list.elements[index] = arguments[1]
+			return arguments[1], nil
+		})
+	case "push":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			list.mu.Lock()
+			defer list.mu.Unlock()
+			list.elements = append(list.elements, arguments[0])
+			return nil, nil
+		})
+	case "pop":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			list.mu.Lock()
+			defer list.mu.Unlock()
+			if len(list.elements) == 0 {
+				return nil, fmt.Errorf("Can't pop from an empty list.")
+			}
+			last := list.elements[len(list.elements)-1]
+			list.elements = list.elements[:len(list.elements)-1]
+			return last, nil
+		})
+	case "join":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			separator, err := interpreter.stringArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			elements := list.snapshot()
+			parts := make([]string, len(elements))
+			for index, element := range elements {
+				parts[index] = interpreter.Stringify(element)
+			}
+			return strings.Join(parts, separator), nil
+		})
+	}
+	return nil
+}
+
+// LoxMap is a hash map from Lox values to Lox values that remembers insertion
+// order. Like lists, maps can be shared by concurrent tasks and are guarded by a lock.
+type LoxMap struct {
+	mu     sync.RWMutex
+	keys   []interface{}
+	values map[interface{}]interface{}
+}
+
+func NewLoxMap() *LoxMap {
+	return &LoxMap{
+		keys:   []interface{}{},
+		values: make(map[interface{}]interface{}),
+	}
+}
+
+// Get returns the value stored under key and whether it was present
+func (m *LoxMap) Get(key interface{}) (interface{}, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.values[key]
+	return value, ok
+}
+
+// Set stores value under key, appending new keys to the iteration order
+func (m *LoxMap) Set(key interface{}, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Remove deletes key from the map, reporting whether it was present
+func (m *LoxMap) Remove(key interface{}) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values[key]; !ok {
+		return false
+	}
+	delete(m.values, key)
+	for index, existing := range m.keys {
+		if existing == key {
+			m.keys = append(m.keys[:index], m.keys[index+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Len returns the number of entries
+func (m *LoxMap) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.keys)
+}
+
+// entries returns copies of the keys and their values in insertion order, for
+// reading them without holding the lock
+func (m *LoxMap) entries() ([]interface{}, []interface{}) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := append([]interface{}(nil), m.keys...)
+	values := make([]interface{}, len(keys))
+	for index, key := range keys {
+		values[index] = m.values[key]
+	}
+	return keys, values
+}
+
+// mapKey checks that a value can be used as a map key
+func mapKey(name string, key interface{}) (interface{}, error) {
+	switch key.(type) {
+	case nil, bool, float64, string, *LoxInstance, *LoxClass:
+		return key, nil
+	}
+	return nil, fmt.Errorf("Invalid key passed to '%s'; keys must be numbers, strings, booleans, nil, instances or classes.", name)
+}
+
+// mapMethod returns the built-in method with the given name bound to the map, or nil
+func mapMethod(m *LoxMap, name string) *NativeFunction {
+	switch name {
+	case "len":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return float64(m.Len()), nil
+		})
+	case "get":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			key, err := mapKey(name, arguments[0])
+			if err != nil {
+				return nil, err
+			}
+			value, _ := m.Get(key)
+			return value, nil
+		})
+	case "set":
+		return NewNativeFunction(name, 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			key, err := mapKey(name, arguments[0])
+			if err != nil {
+				return nil, err
+			}
+			m.Set(key, arguments[1])
+			return arguments[1], nil
+		})
+	case "has":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			key, err := mapKey(name, arguments[0])
+			if err != nil {
+				return nil, err
+			}
+			_, ok := m.Get(key)
+			return ok, nil
+		})
+	case "remove":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			key, err := mapKey(name, arguments[0])
+			if err != nil {
+				return nil, err
+			}
+			return m.Remove(key), nil
+		})
+	case "keys":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			keys, _ := m.entries()
+			return NewLoxList(keys), nil
+		})
+	case "values":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			_, values := m.entries()
+			return NewLoxList(values), nil
+		})
+	}
+	return nil
+}
+
+// LoxRange is a lazy sequence of numbers from start up to (but not including) end
+type LoxRange struct {
+	start float64
+	end   float64
+	step  float64
+}
+
+func NewLoxRange(start, end, step float64) *LoxRange {
+	return &LoxRange{start: start, end: end, step: step}
+}
+
+func (r *LoxRange) String() string {
+	format := func(num float64) string {
+		return strconv.FormatFloat(num, 'f', -1, 64)
+	}
+	return fmt.Sprintf("range(%s, %s, %s)", format(r.start), format(r.end), format(r.step))
+}
+
+// iterator returns a function producing the values of the range in order
+func (r *LoxRange) iterator() func() (interface{}, bool) {
+	next := r.start
+	return func() (interface{}, bool) {
+		if (r.step > 0 && next >= r.end) || (r.step < 0 && next <= r.end) {
+			return nil, false
+		}
+		value := next
+		next += r.step
+		return value, true
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestRangeRejectsBoundsThatNeverEnd(t *testing.T) {
+	cases := map[string]string{
+		"range(0, 1, 0/0);": "Argument 3 to 'range' must be a finite number.",
+		"range(0, 0/0);":    "Argument 2 to 'range' must be a finite number.",
+		"range(-1/0, 0);":   "Argument 1 to 'range' must be a finite number.",
+		"range(0, 1/0);":    "Argument 2 to 'range' must be a finite number.",
+		"range(0, 5, 0);":   "Range step can't be zero.",
+		"range(10000000000000000, 10000000000000010, 1);": "Range step is too small to move past the start.",
+	}
+	for source, want := range cases {
+		_, errors := runFailingScript(t, source, Options{})
+		if want += "\n[line 1]\n"; errors != want {
+			t.Errorf("%s got error %q, want %q", source, errors, want)
+		}
+	}
+}
+
+func TestRangeIteration(t *testing.T) {
+	source := `
+for (var x in range(3)) print x;
+for (var x in range(5, 0, -2)) print x;
+for (var x in range(0, 1, 0.5)) print x;
+for (var x in range(2, 2)) print x;
+print range(1, 4);
+`
+	want := "0\n1\n2\n5\n3\n1\n0\n0.5\nrange(1, 4, 1)\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestForInIteratesEachKind(t *testing.T) {
+	source := `
+for (var x in list(1, 2, 3)) { if (x == 2) continue; print x; }
+var m = map(); m.set("a", 1); m.set("b", 2);
+for (var k in m) print k + "=" + str(m.get(k));
+for (var c in "hé") print c;
+var fns = list();
+for (var i in range(3)) { fun f() { return i; } fns.push(f); }
+for (var f in fns) print f();
+class Countdown {
+  init(n) { this.n = n; }
+  iterator() { return CountdownIter(this.n); }
+}
+class CountdownIter {
+  init(n) { this.n = n; }
+  hasNext() { return this.n > 0; }
+  next() { this.n = this.n - 1; return this.n + 1; }
+}
+for (var v in Countdown(3)) print v;
+fun firstOver(limit) { for (var v in range(100)) { if (v > limit) return v; } }
+print firstOver(41);
+for (var k in m) { m.set("c", 3); print k; }
+`
+	// Each iteration gets its own variable, and a map's keys are taken when the
+	// loop starts
+	want := "1\n3\na=1\nb=2\nh\né\n0\n1\n2\n3\n2\n1\n42\na\nb\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestForInErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"for (var z in 5) print z;", "Can only iterate over lists, maps, strings, ranges, generators, channels and iterable instances.\n[line 1]\n"},
+		{"class A { iterator() { return 1; } }\nfor (var x in A()) print x;", "Can only iterate over lists, maps, strings, ranges, generators, channels and iterable instances.\n[line 2]\n"},
+		{"class I { hasNext() { return true; } }\nclass A { iterator() { return I(); } }\nfor (var x in A()) print x;", "Iterator must have hasNext() and next() methods.\n[line 3]\n"},
+		{"class I { hasNext() { return true; } next() { return 1 / nil; } }\nclass A { iterator() { return I(); } }\nfor (var x in A()) print x;", "Operands must be numbers.\n[line 1]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
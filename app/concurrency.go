@@ -0,0 +1,448 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// scheduler tracks the tasks that can still make progress, so a channel operation
+// or join that nothing could ever complete is reported as an error instead of
+// blocking forever. The main program counts as a task. One lock guards the count
+// and the state of every channel, which lets a task that wakes another count it
+// as running again before anything else can block.
+type scheduler struct {
+	mu      sync.Mutex
+	running int       // tasks not blocked on a channel or join
+	blocked []*waiter // operations waiting for another task
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{running: 1}
+}
+
+// waiter is a task blocked on a channel operation or a join. It's woken once, by
+// the task completing the operation or by the scheduler finding it never can be.
+type waiter struct {
+	wake    chan struct{}
+	done    bool
+	value   interface{} // the value to send, or the value received
+	ok      bool        // a value was received rather than the channel closing
+	channel *LoxChannel // the channel that woke a select
+	err     error       // why the operation failed
+
+	deadlock error // the error to fail with if nothing can ever wake the waiter
+}
+
+func newWaiter(value interface{}) *waiter {
+	return &waiter{wake: make(chan struct{}, 1), value: value}
+}
+
+// block parks the calling task until w is woken. s.mu must be held; it's released
+// while waiting. If no other task is running, nothing can ever wake w, so every
+// blocked operation fails with its deadlock error instead.
+func (s *scheduler) block(w *waiter, deadlock error) error {
+	w.deadlock = deadlock
+	s.blocked = append(s.blocked, w)
+	s.running--
+	s.checkDeadlock()
+	s.mu.Unlock()
+	<-w.wake
+	s.mu.Lock()
+	return w.err
+}
+
+// release wakes a blocked waiter, counting its task as running again. s.mu must
+// be held.
+func (s *scheduler) release(w *waiter) {
+	w.done = true
+	s.running++
+	for index, blocked := range s.blocked {
+		if blocked == w {
+			s.blocked = append(s.blocked[:index], s.blocked[index+1:]...)
+			break
+		}
+	}
+	w.wake <- struct{}{}
+}
+
+// fail wakes a blocked waiter with an error. s.mu must be held.
+func (s *scheduler) fail(w *waiter, err error) {
+	w.err = err
+	s.release(w)
+}
+
+// othersRunning reports whether any task besides the calling one is running
+func (s *scheduler) othersRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running > 1
+}
+
+// checkDeadlock fails every blocked operation once no task is left running.
+// s.mu must be held.
+func (s *scheduler) checkDeadlock() {
+	if s.running > 0 {
+		return
+	}
+	for len(s.blocked) > 0 {
+		s.fail(s.blocked[0], s.blocked[0].deadlock)
+	}
+}
+
+// LoxTask is a function call running concurrently, started with "spawn". The call
+// runs on its own goroutine with a forked interpreter, so it has its own current
+// environment and error state while sharing globals with the spawning code.
+type LoxTask struct {
+	name    string
+	done    chan struct{}
+	result  interface{}
+	frames  *frameStack // the frames of the task's interpreter while it runs
+	mu      sync.Mutex
+	err     error
+	sched   *scheduler
+	joiners []*waiter // guarded by sched.mu
+}
+
+func (t *LoxTask) String() string {
+	return fmt.Sprintf("<task %s>", t.name)
+}
+
+// fail records the first runtime error raised by the task
+func (t *LoxTask) fail(message string, line int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = fmt.Errorf("Task %s failed at line %d: %s", t.name, line, message)
+	}
+}
+
+// finish marks the task as done, waking the tasks joining it
+func (t *LoxTask) finish() {
+	t.sched.mu.Lock()
+	defer t.sched.mu.Unlock()
+	close(t.done)
+	for _, joiner := range t.joiners {
+		t.sched.release(joiner)
+	}
+	t.joiners = nil
+	t.sched.running--
+	t.sched.checkDeadlock()
+}
+
+// Join waits for the task to finish and returns its result or error
+func (t *LoxTask) Join() (interface{}, error) {
+	t.sched.mu.Lock()
+	select {
+	case <-t.done:
+	default:
+		joiner := newWaiter(nil)
+		t.joiners = append(t.joiners, joiner)
+		if err := t.sched.block(joiner, errors.New("Join would block forever.")); err != nil {
+			t.sched.mu.Unlock()
+			return nil, err
+		}
+	}
+	t.sched.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.result, t.err
+}
+
+// VisitSpawnExpr starts a call on a new goroutine and returns its task
+func (i *Interpreter) VisitSpawnExpr(expr *Spawn) interface{} {
+	defer i.frames.truncate(i.frames.depth())
+	function, arguments, ok := i.evaluateCall(expr.Call)
+	if !ok {
+		return nil
+	}
+
+	task := &LoxTask{name: i.Stringify(function), done: make(chan struct{}), sched: i.sched}
+	worker := i.fork(i.environment)
+	worker.errorSink = task
+	worker.callToken = expr.Call.Paren
+
+	// The function and its arguments are roots for as long as the task runs
+	worker.frames.push(function)
+	for _, argument := range arguments {
+		worker.frames.push(argument)
+	}
+	i.heap.activate(worker)
+	task.frames = worker.frames
+
+	i.sched.mu.Lock()
+	i.sched.running++
+	i.sched.mu.Unlock()
+
+	go func() {
+		defer task.finish()
+		defer i.heap.deactivate(worker)
+		defer func() {
+			if r := recover(); r != nil {
+				worker.internalError(r)
+			}
+		}()
+
+		result := function.Call(worker, arguments)
+		task.mu.Lock()
+		task.result, task.frames = result, nil
+		task.mu.Unlock()
+	}()
+
+	return task
+}
+
+// taskMethod returns the built-in method with the given name bound to the task, or nil
+func taskMethod(task *LoxTask, name string) *NativeFunction {
+	switch name {
+	case "join":
+		// Errors raised by the task are re-raised at the join
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return task.Join()
+		})
+	case "done":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			select {
+			case <-task.done:
+				return true, nil
+			default:
+				return false, nil
+			}
+		})
+	}
+	return nil
+}
+
+// LoxChannel carries Lox values between tasks. A channel with no capacity hands
+// each value directly from a sender to a receiver. Its state is guarded by the
+// scheduler's lock, so blocking can be checked against the tasks still running.
+type LoxChannel struct {
+	sched     *scheduler
+	capacity  int
+	buffer    []interface{}
+	closed    bool
+	receivers []*waiter // blocked receivers, including selects
+	senders   []*waiter // blocked senders with their values
+}
+
+func (c *LoxChannel) String() string {
+	return "<channel>"
+}
+
+// roots returns the values in the channel: those buffered and those blocked senders
+// are waiting to hand over
+func (c *LoxChannel) roots() []interface{} {
+	c.sched.mu.Lock()
+	defer c.sched.mu.Unlock()
+	roots := append([]interface{}(nil), c.buffer...)
+	for _, sender := range c.senders {
+		if !sender.done {
+			roots = append(roots, sender.value)
+		}
+	}
+	return roots
+}
+
+// nextWaiter removes and returns the first waiter in a queue that hasn't already
+// been woken by another channel, or nil
+func nextWaiter(queue *[]*waiter) *waiter {
+	for len(*queue) > 0 {
+		w := (*queue)[0]
+		*queue = (*queue)[1:]
+		if !w.done {
+			return w
+		}
+	}
+	return nil
+}
+
+// Send sends a value, failing if the channel has been closed
+func (c *LoxChannel) Send(value interface{}) error {
+	c.sched.mu.Lock()
+	defer c.sched.mu.Unlock()
+	if c.closed {
+		return errors.New("Send on closed channel.")
+	}
+	if receiver := nextWaiter(&c.receivers); receiver != nil {
+		receiver.value, receiver.ok, receiver.channel = value, true, c
+		c.sched.release(receiver)
+		return nil
+	}
+	if len(c.buffer) < c.capacity {
+		c.buffer = append(c.buffer, value)
+		return nil
+	}
+
+	sender := newWaiter(value)
+	c.senders = append(c.senders, sender)
+	return c.sched.block(sender, errors.New("Send would block forever."))
+}
+
+// tryReceive receives a value if one is ready or the channel is closed, reporting
+// whether it did. c.sched.mu must be held.
+func (c *LoxChannel) tryReceive() (value interface{}, ok bool, ready bool) {
+	if len(c.buffer) > 0 {
+		value = c.buffer[0]
+		c.buffer = c.buffer[1:]
+		// Room has opened up for a blocked sender
+		if sender := nextWaiter(&c.senders); sender != nil {
+			c.buffer = append(c.buffer, sender.value)
+			sender.ok = true
+			c.sched.release(sender)
+		}
+		return value, true, true
+	}
+	if sender := nextWaiter(&c.senders); sender != nil {
+		sender.ok = true
+		c.sched.release(sender)
+		return sender.value, true, true
+	}
+	if c.closed {
+		return nil, false, true
+	}
+	return nil, false, false
+}
+
+// Receive waits for a value, returning false once the channel is closed and drained
+func (c *LoxChannel) Receive() (interface{}, bool, error) {
+	c.sched.mu.Lock()
+	defer c.sched.mu.Unlock()
+	if value, ok, ready := c.tryReceive(); ready {
+		return value, ok, nil
+	}
+
+	receiver := newWaiter(nil)
+	c.receivers = append(c.receivers, receiver)
+	if err := c.sched.block(receiver, errors.New("Receive would block forever.")); err != nil {
+		return nil, false, err
+	}
+	return receiver.value, receiver.ok, nil
+}
+
+// Close closes the channel, failing if it is already closed. Blocked receivers get
+// nil and blocked senders fail.
+func (c *LoxChannel) Close() error {
+	c.sched.mu.Lock()
+	defer c.sched.mu.Unlock()
+	if c.closed {
+		return errors.New("Channel is already closed.")
+	}
+	c.closed = true
+	for receiver := nextWaiter(&c.receivers); receiver != nil; receiver = nextWaiter(&c.receivers) {
+		receiver.channel = c
+		c.sched.release(receiver)
+	}
+	for sender := nextWaiter(&c.senders); sender != nil; sender = nextWaiter(&c.senders) {
+		c.sched.fail(sender, errors.New("Send on closed channel."))
+	}
+	return nil
+}
+
+// selectReceive waits until one of the channels can be received from, returning
+// its index, the value and whether a value was received rather than the channel
+// closing. Channels are tried in order, so an earlier ready channel wins.
+func selectReceive(sched *scheduler, channels []*LoxChannel) (int, interface{}, bool, error) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	for index, channel := range channels {
+		if value, ok, ready := channel.tryReceive(); ready {
+			return index, value, ok, nil
+		}
+	}
+
+	receiver := newWaiter(nil)
+	for _, channel := range channels {
+		channel.receivers = append(channel.receivers, receiver)
+	}
+	err := sched.block(receiver, errors.New("Select would block forever."))
+	// Drop the waiter from the channels that didn't wake it
+	for _, channel := range channels {
+		for index, waiting := range channel.receivers {
+			if waiting == receiver {
+				channel.receivers = append(channel.receivers[:index], channel.receivers[index+1:]...)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	for index, channel := range channels {
+		if channel == receiver.channel {
+			return index, receiver.value, receiver.ok, nil
+		}
+	}
+	return 0, nil, false, nil
+}
+
+// channelMethod returns the built-in method with the given name bound to the
+// channel, or nil
+func channelMethod(channel *LoxChannel, name string) *NativeFunction {
+	switch name {
+	case "send":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return nil, channel.Send(arguments[0])
+		})
+	case "receive":
+		// Returns nil once the channel is closed and drained
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			value, _, err := channel.Receive()
+			return value, err
+		})
+	case "close":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return nil, channel.Close()
+		})
+	}
+	return nil
+}
+
+// defineConcurrencyNatives registers channel() and select() in the given environment
+func defineConcurrencyNatives(env *Environment) {
+	env.Define("channel", NewNativeFunction("channel", -1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		if len(arguments) > 1 {
+			return nil, fmt.Errorf("Expected 0 or 1 arguments but got %d.", len(arguments))
+		}
+		capacity := 0
+		if len(arguments) == 1 {
+			var err error
+			capacity, err = interpreter.integerArg("channel", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			if capacity < 0 {
+				return nil, fmt.Errorf("Channel capacity can't be negative.")
+			}
+		}
+		return &LoxChannel{sched: interpreter.sched, capacity: capacity}, nil
+	}))
+
+	// select(channels) waits until one of the channels can be received from and
+	// returns a list of its index and the received value (nil if it was closed)
+	env.Define("select", NewNativeFunction("select", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		list, err := interpreter.listArg("select", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		elements := list.snapshot()
+		if len(elements) == 0 {
+			return nil, fmt.Errorf("Can't select from an empty list.")
+		}
+
+		channels := make([]*LoxChannel, len(elements))
+		for index, element := range elements {
+			channel, ok := element.(*LoxChannel)
+			if !ok {
+				return nil, fmt.Errorf("select() expects a list of channels.")
+			}
+			channels[index] = channel
+		}
+
+		chosen, value, _, err := selectReceive(interpreter.sched, channels)
+		if err != nil {
+			return nil, err
+		}
+		return NewLoxList([]interface{}{float64(chosen), value}), nil
+	}))
+}
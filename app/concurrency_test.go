@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestTasksAndChannels(t *testing.T) {
+	source := `
+fun work(ch, n) { var i = 0; while (i < n) { ch.send(i); i = i + 1; } ch.close(); return n * 2; }
+var ch = channel();
+var t = spawn work(ch, 5);
+var sum = 0;
+for (var v in ch) sum = sum + v;
+print sum;
+print t.join();
+print t.join();
+print t;
+print ch.receive();
+var c1 = channel(1); var c2 = channel(1);
+c2.send("two");
+print select(list(c1, c2));
+`
+	// A closed, drained channel receives nil, and joining twice returns the same result
+	want := "10\n10\n10\n<task <fn work>>\nnil\n[1, two]\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestJoinReportsTaskFailure(t *testing.T) {
+	printed, errors := runFailingScript(t, `
+fun fails() { return nil + 1; }
+var f = spawn fails();
+print "before join";
+f.join();
+print "unreachable";
+`, Options{})
+
+	if printed != "before join\n" {
+		t.Errorf("got output %q", printed)
+	}
+	if want := "Task <fn fails> failed at line 2: Operands must be two numbers or two strings.\n[line 5]\n"; errors != want {
+		t.Errorf("got error %q, want %q", errors, want)
+	}
+}
+
+func TestChannelErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"var c = channel(); c.close(); c.send(1);", "Send on closed channel.\n[line 1]\n"},
+		{"var c = channel(); c.close(); c.close();", "Channel is already closed.\n[line 1]\n"},
+		{"channel(-1);", "Channel capacity can't be negative.\n[line 1]\n"},
+		{"select(list());", "Can't select from an empty list.\n[line 1]\n"},
+		{"select(list(1));", "select() expects a list of channels.\n[line 1]\n"},
+		{"var c = channel(); c.receive();", "Receive would block forever.\n[line 1]\n"},
+		{"var c = channel(); c.send(1);", "Send would block forever.\n[line 1]\n"},
+		{"var c = channel(); select(list(c));", "Select would block forever.\n[line 1]\n"},
+		{"var c = channel();\nfun wait() { c.receive(); }\nvar t = spawn wait();\nt.join();", "Join would block forever.\n[line 4]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
@@ -2,12 +2,22 @@ package main
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 )
 
-// Environment stores variable bindings
+// Environment stores variable bindings. The global environment keeps its bindings
+// in a table indexed by name; local environments keep them in slots, in the order
+// the resolver assigned, so resolved accesses index directly instead of hashing the
+// name. Environments can be shared by concurrent tasks through closures, so access
+// to the bindings is guarded by a lock.
 type Environment struct {
-	values    map[string]interface{}
+	mu        sync.RWMutex
+	values    map[string]interface{} // globals only
+	names     []string               // names of the local slots, for lookups by name
+	slots     []interface{}
 	enclosing *Environment
+	tracked   atomic.Bool // registered with the interpreter's heap
 }
 
 func NewEnvironment() *Environment {
@@ -19,19 +29,80 @@ func NewEnvironment() *Environment {
 
 func NewEnclosedEnvironment(enclosing *Environment) *Environment {
 	return &Environment{
-		values:    make(map[string]interface{}),
 		enclosing: enclosing,
 	}
 }
 
-// Define adds a new variable to the environment
+// newLocalEnvironment returns an enclosed environment with room for size slots,
+// so defining that many variables doesn't grow the slices
+func newLocalEnvironment(enclosing *Environment, size int) *Environment {
+	return &Environment{
+		names:     make([]string, 0, size),
+		slots:     make([]interface{}, 0, size),
+		enclosing: enclosing,
+	}
+}
+
+// Define adds a new variable to the environment. Local variables take the next
+// slot, which matches the slot the resolver gave their declaration.
 func (e *Environment) Define(name string, value interface{}) {
-	e.values[name] = value
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.values != nil {
+		e.values[name] = value
+		return
+	}
+	e.names = append(e.names, name)
+	e.slots = append(e.slots, value)
+}
+
+// slotOf returns the slot holding name in a local environment, or -1
+func (e *Environment) slotOf(name string) int {
+	for slot := len(e.names) - 1; slot >= 0; slot-- {
+		if e.names[slot] == name {
+			return slot
+		}
+	}
+	return -1
+}
+
+// lookup returns the binding for name in this environment only
+func (e *Environment) lookup(name string) (interface{}, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.values != nil {
+		value, ok := e.values[name]
+		return value, ok
+	}
+	if slot := e.slotOf(name); slot >= 0 {
+		return e.slots[slot], true
+	}
+	return nil, false
+}
+
+// update overwrites an existing binding in this environment only, reporting
+// whether the name was bound here
+func (e *Environment) update(name string, value interface{}) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.values != nil {
+		if _, ok := e.values[name]; !ok {
+			return false
+		}
+		e.values[name] = value
+		return true
+	}
+	slot := e.slotOf(name)
+	if slot < 0 {
+		return false
+	}
+	e.slots[slot] = value
+	return true
 }
 
 // Get retrieves a variable's value from the environment
 func (e *Environment) Get(name Token) (interface{}, error) {
-	if value, ok := e.values[name.Lexeme]; ok {
+	if value, ok := e.lookup(name.Lexeme); ok {
 		return value, nil
 	}
 
@@ -45,8 +116,7 @@ func (e *Environment) Get(name Token) (interface{}, error) {
 
 // Assign updates an existing variable's value in the environment
 func (e *Environment) Assign(name Token, value interface{}) error {
-	if _, ok := e.values[name.Lexeme]; ok {
-		e.values[name.Lexeme] = value
+	if e.update(name.Lexeme, value) {
 		return nil
 	}
 
@@ -58,14 +128,28 @@ func (e *Environment) Assign(name Token, value interface{}) error {
 	return fmt.Errorf("Undefined variable '%s'.", name.Lexeme)
 }
 
-// GetAt retrieves a variable's value at a specific depth in the environment chain
-func (e *Environment) GetAt(distance int, name string) interface{} {
-	return e.ancestor(distance).values[name]
+// GetAt retrieves the local variable in the given slot of the environment at a
+// specific depth in the environment chain
+func (e *Environment) GetAt(distance int, slot int) interface{} {
+	environment := e.ancestor(distance)
+	environment.mu.RLock()
+	var value interface{}
+	if slot < len(environment.slots) {
+		value = environment.slots[slot]
+	}
+	environment.mu.RUnlock()
+	return value
 }
 
-// AssignAt updates a variable's value at a specific depth in the environment chain
-func (e *Environment) AssignAt(distance int, name Token, value interface{}) {
-	e.ancestor(distance).values[name.Lexeme] = value
+// AssignAt updates the local variable in the given slot of the environment at a
+// specific depth in the environment chain
+func (e *Environment) AssignAt(distance int, slot int, value interface{}) {
+	environment := e.ancestor(distance)
+	environment.mu.Lock()
+	if slot < len(environment.slots) {
+		environment.slots[slot] = value
+	}
+	environment.mu.Unlock()
 }
 
 // ancestor walks up the environment chain to find the environment at the given distance
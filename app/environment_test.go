@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestLocalsResolveToTheirSlots(t *testing.T) {
+	source := `
+fun outer() {
+  var a = 1;
+  class Base { greet() { return "base"; } }
+  class Derived < Base {
+    init(n) { this.n = n; }
+    greet() { return super.greet() + " derived " + str(this.n) + " " + str(a); }
+  }
+  var b = 2;
+  {
+    var c = 3;
+    fun inner() { a = a + b + c; return a; }
+    print inner();
+    print inner();
+  }
+  for (var x in list(10, 20)) {
+    var y = x + b;
+    print y;
+  }
+  print Derived(5).greet();
+  return a;
+}
+print outer();
+var a = "global";
+{
+  fun show() { print a; }
+  show();
+  var a = "block";
+  show();
+}
+fun counter() { var n = 0; fun inc() { n = n + 1; return n; } return inc; }
+var c1 = counter(); var c2 = counter();
+c1();
+print c1();
+print c2();
+fun late() { return laterGlobal(); }
+fun laterGlobal() { return "late global"; }
+print late();
+fun unset() { var x; print x; x = 3; print x; }
+unset();
+`
+	// A closure keeps the variable it resolved to even when a later declaration
+	// shadows the name, and each call gets its own slots
+	want := "6\n11\n12\n22\nbase derived 5 11\n11\nglobal\nglobal\n2\n1\nlate global\nnil\n3\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestVariableResolutionErrors(t *testing.T) {
+	runtime := []struct {
+		source string
+		want   string
+	}{
+		{"fun f() { return missing; }\nf();", "Undefined variable 'missing'.\n[line 1]\n"},
+		{"fun f() { missing = 1; }\nf();", "Undefined variable 'missing'.\n[line 1]\n"},
+	}
+	for _, test := range runtime {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+
+	compile := []struct {
+		source string
+		want   string
+	}{
+		{"{ var a = 1; var a = 2; }", "[line 1] Error at 'a': Already a variable with this name in this scope.\n"},
+		{"{ var a = a; }", "[line 1] Error at 'a': Can't read local variable in its own initializer.\n"},
+	}
+	for _, test := range compile {
+		if errors := compileErrors(t, test.source); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// LoxGenerator is the object returned by calling a generator function. Its body
+// runs on the goroutine asking for the next value, up to the next yield, and is
+// then suspended as a stack of frames: the blocks and loops it was partway
+// through. Nothing but the handle refers to a suspended body, so an abandoned
+// generator is collected like any other object.
+type LoxGenerator struct {
+	name string
+
+	mu      sync.Mutex
+	body    *Interpreter // runs the body; its environment is the innermost frame's
+	frames  []*generatorFrame
+	running bool
+	pending interface{} // value fetched by hasNext() but not yet consumed
+	ready   bool        // pending holds a value
+	done    bool
+}
+
+// generatorFrame is a statement of a generator's body that is partway through
+// running: a list of statements, a while loop or a for-in loop. Only statements
+// that can contain a yield get a frame; everything else runs with Execute.
+type generatorFrame struct {
+	statements []Stmt // a list of statements and the index of the next to run
+	index      int
+
+	loop    *While // a while loop
+	looping bool   // the loop's body has run, so its increment is due
+
+	forIn    *ForIn // a for-in loop, the value it iterates over and its iterator
+	iterable interface{}
+	next     func() (interface{}, bool)
+
+	previous *Environment // environment to restore when the frame is popped
+	depth    int          // depth of the body's frame stack to return to then
+}
+
+// errGeneratorRunning is reported when a generator is resumed while its body runs
+var errGeneratorRunning = errors.New("Generator is already running.")
+
+func NewLoxGenerator(interpreter *Interpreter, function *LoxFunction, environment *Environment) *LoxGenerator {
+	generator := &LoxGenerator{
+		name: function.declaration.Name.Lexeme,
+		body: interpreter.fork(environment),
+	}
+	generator.push(&generatorFrame{statements: function.declaration.Body}, environment)
+	return generator
+}
+
+// advance runs the body to its next yield unless a value is already pending
+func (g *LoxGenerator) advance(interpreter *Interpreter) {
+	g.mu.Lock()
+	if g.done || g.ready {
+		g.mu.Unlock()
+		return
+	}
+	if g.running {
+		g.mu.Unlock()
+		interpreter.runtimeError(interpreter.callToken, errGeneratorRunning.Error())
+		return
+	}
+	g.running = true
+	g.mu.Unlock()
+
+	// The generator itself may be reachable from nothing else while it runs
+	interpreter.heap.activate(g.body)
+	value, yielded := g.resume()
+	interpreter.heap.deactivate(g.body)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.running = false
+	if yielded {
+		g.pending, g.ready = value, true
+		return
+	}
+	if g.body.hadRuntimeError {
+		// The error has already been reported by the generator's interpreter
+		interpreter.hadRuntimeError = true
+	}
+	g.done = true
+	g.body, g.frames = nil, nil
+}
+
+// Next returns the next yielded value, reporting false once the generator is done
+func (g *LoxGenerator) Next(interpreter *Interpreter) (interface{}, bool) {
+	g.advance(interpreter)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.ready {
+		return nil, false
+	}
+	value := g.pending
+	g.pending, g.ready = nil, false
+	return value, true
+}
+
+// hasNext reports whether the generator has another value, running it to its
+// next yield to find out
+func (g *LoxGenerator) hasNext(interpreter *Interpreter) bool {
+	g.advance(interpreter)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.ready
+}
+
+// push enters a frame, making environment the body's current one. The body's
+// frame stack gets the environment, or the value a for-in loop iterates over, so
+// a suspended generator keeps them reachable.
+func (g *LoxGenerator) push(frame *generatorFrame, environment *Environment) {
+	frame.previous = g.body.environment
+	frame.depth = g.body.frames.depth()
+	g.body.environment = environment
+	g.frames = append(g.frames, frame)
+	if frame.forIn != nil {
+		g.body.frames.push(frame.iterable)
+	} else {
+		g.body.frames.push(environment)
+	}
+}
+
+// pop leaves the innermost frame, restoring the environment it was entered from
+func (g *LoxGenerator) pop() {
+	frame := g.frames[len(g.frames)-1]
+	g.frames = g.frames[:len(g.frames)-1]
+	g.body.environment = frame.previous
+	g.body.frames.truncate(frame.depth)
+}
+
+// resume runs the body until it yields, returning the value, or until it finishes
+func (g *LoxGenerator) resume() (interface{}, bool) {
+	body := g.body
+	for len(g.frames) > 0 && !body.hadRuntimeError {
+		frame := g.frames[len(g.frames)-1]
+
+		var stmt Stmt
+		switch {
+		case frame.loop != nil:
+			if frame.looping && frame.loop.Increment != nil {
+				if body.Evaluate(frame.loop.Increment); body.hadRuntimeError {
+					return nil, false
+				}
+			}
+			frame.looping = true
+			condition := body.Evaluate(frame.loop.Condition)
+			if body.hadRuntimeError {
+				return nil, false
+			}
+			if !body.isTruthy(condition) {
+				g.pop()
+				continue
+			}
+			stmt = frame.loop.Body
+
+		case frame.forIn != nil:
+			value, ok := frame.next()
+			if body.hadRuntimeError {
+				return nil, false
+			}
+			if !ok {
+				g.pop()
+				continue
+			}
+			// Each iteration runs in a fresh environment, as in VisitForInStmt
+			environment := newLocalEnvironment(body.environment, 1)
+			environment.Define(frame.forIn.Name.Lexeme, value)
+			g.push(&generatorFrame{statements: []Stmt{frame.forIn.Body}}, environment)
+			continue
+
+		default:
+			if frame.index == len(frame.statements) {
+				g.pop()
+				continue
+			}
+			stmt = frame.statements[frame.index]
+			frame.index++
+		}
+
+		if value, yielded := g.step(stmt); yielded {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// step runs one statement of the body. A statement that can contain a yield
+// pushes a frame to be run by resume; a yield returns its value.
+func (g *LoxGenerator) step(stmt Stmt) (interface{}, bool) {
+	body := g.body
+	switch stmt := stmt.(type) {
+	case *Yield:
+		var value interface{}
+		if stmt.Value != nil {
+			if value = body.Evaluate(stmt.Value); body.hadRuntimeError {
+				return nil, false
+			}
+		}
+		return value, true
+
+	case *Block:
+		g.push(&generatorFrame{statements: stmt.Statements}, NewEnclosedEnvironment(body.environment))
+
+	case *If:
+		condition := body.Evaluate(stmt.Condition)
+		if body.hadRuntimeError {
+			return nil, false
+		}
+		if body.isTruthy(condition) {
+			return g.step(stmt.ThenBranch)
+		} else if stmt.ElseBranch != nil {
+			return g.step(stmt.ElseBranch)
+		}
+
+	case *While:
+		g.push(&generatorFrame{loop: stmt}, body.environment)
+
+	case *ForIn:
+		iterable := body.Evaluate(stmt.Iterable)
+		if body.hadRuntimeError {
+			return nil, false
+		}
+		// The frame is entered first so that it also holds on to the iterator
+		frame := &generatorFrame{forIn: stmt, iterable: iterable}
+		g.push(frame, body.environment)
+		if frame.next = body.iterator(stmt.Name, iterable); frame.next == nil {
+			g.pop()
+		}
+
+	default:
+		if result := body.Execute(stmt); result != nil {
+			g.unwind(result)
+		}
+	}
+	return nil, false
+}
+
+// unwind pops frames for a statement that completed abruptly: up to the innermost
+// loop for break and continue, and the whole body for return or an error
+func (g *LoxGenerator) unwind(result *completion) {
+	for len(g.frames) > 0 {
+		frame := g.frames[len(g.frames)-1]
+		if result.kind == completeContinue && (frame.loop != nil || frame.forIn != nil) {
+			return
+		}
+		g.pop()
+		if result.kind == completeBreak && (frame.loop != nil || frame.forIn != nil) {
+			return
+		}
+	}
+}
+
+func (g *LoxGenerator) String() string {
+	return fmt.Sprintf("<generator %s>", g.name)
+}
+
+// generatorMethod returns the built-in method with the given name bound to the
+// generator, or nil
+func generatorMethod(generator *LoxGenerator, name string) *NativeFunction {
+	switch name {
+	case "next":
+		// Returns nil once the generator is exhausted
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			value, _ := generator.Next(interpreter)
+			return value, nil
+		})
+	case "hasNext":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return generator.hasNext(interpreter), nil
+		})
+	}
+	return nil
+}
+
+// VisitYieldStmt is never reached for a valid program: the resolver only allows
+// yield in a generator's body, and the generator runs yields itself (see
+// LoxGenerator.step) rather than through Execute
+func (i *Interpreter) VisitYieldStmt(stmt *Yield) interface{} {
+	i.runtimeError(stmt.Keyword, "Can't use 'yield' outside of a generator.")
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestIteratorMethodCanReturnAnyIterable(t *testing.T) {
+	source := `
+class Tree { *iterator() { yield 1; yield 2; } }
+for (var x in Tree()) print x;
+class Wrap { init(l) { this.l = l; } iterator() { return this.l; } }
+for (var x in Wrap(list("a", "b"))) print x;
+fun* tens() { for (var x in Tree()) yield x * 10; }
+for (var x in tens()) print x;
+`
+	want := "1\n2\na\nb\n10\n20\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	_, errors := runFailingScript(t, "class Bad { iterator() { return 5; } }\nfor (var x in Bad()) print x;", Options{})
+	if want := "Can only iterate over lists, maps, strings, ranges, generators, channels and iterable instances.\n[line 2]\n"; errors != want {
+		t.Errorf("got error %q, want %q", errors, want)
+	}
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"weak"
+)
+
+// objectHeap is the registry of the objects a script allocates: instances, classes,
+// functions and the environments they capture. gc() collects it with a mark and
+// sweep: it marks every object reachable from the roots (the globals, the frames of
+// the interpreters running code, and the callbacks waiting on the event loop) and
+// sweeps the rest out of the registry, running onCollect for unreachable instances
+// and clearing weak references to unreachable objects. Cycles are collected like
+// any other garbage.
+//
+// The registry holds most objects through weak pointers, so it never keeps their
+// memory alive; Go reclaims it once nothing refers to them, and the registry drops
+// those entries as it grows. Instances with an onCollect method are the exception:
+// they're held until a sweep finds them unreachable, so the method always runs.
+//
+// A collection can't see what a task running on another goroutine is doing, so
+// gc() fails while any task other than the one calling it is running.
+type objectHeap struct {
+	mu          sync.Mutex
+	objects     []heapObject
+	nextSweep   int // number of entries at which track sweeps before growing
+	allocated   int
+	collections int
+	active      []*frameStack              // frames of the interpreters running code, in the order they started
+	finalizable []*LoxInstance             // instances with onCollect, until they're swept
+	weakRefs    []weak.Pointer[LoxWeakRef] // weak references that haven't been cleared
+
+	finalizeMu sync.Mutex
+	finalize   []*LoxInstance // swept instances waiting for their onCollect call
+}
+
+// minHeapSweep is the fewest entries the heap holds before it sweeps on its own
+const minHeapSweep = 4096
+
+// heapObject is a registry entry: what kind of object it is and the object itself
+type heapObject interface {
+	target() interface{} // the object, or nil once Go has reclaimed it
+	kind() string
+	class() string // the class of an instance, empty for other kinds
+}
+
+// heapRef is the registry entry for an object of type T
+type heapRef[T any] struct {
+	pointer   weak.Pointer[T]
+	kindName  string
+	className string
+}
+
+func (r *heapRef[T]) target() interface{} {
+	if object := r.pointer.Value(); object != nil {
+		return object
+	}
+	return nil
+}
+
+func (r *heapRef[T]) kind() string  { return r.kindName }
+func (r *heapRef[T]) class() string { return r.className }
+
+func newObjectHeap() *objectHeap {
+	return &objectHeap{nextSweep: minHeapSweep}
+}
+
+// frameStack holds what an interpreter is in the middle of: the environments it has
+// entered and not yet left, innermost last, the values its for-in loops are
+// iterating over, and the values it's holding while it evaluates the rest of an
+// expression, such as a call's callee and the arguments evaluated so far. Only the
+// interpreter's own goroutine changes it, and a collection only reads the frames
+// of another interpreter while that one is stopped, so it needs no lock.
+type frameStack struct {
+	frames []interface{}
+}
+
+func newFrameStack(environment *Environment) *frameStack {
+	return &frameStack{frames: []interface{}{environment}}
+}
+
+// push enters a frame
+func (s *frameStack) push(frame interface{}) {
+	s.frames = append(s.frames, frame)
+}
+
+// pop leaves the innermost frame
+func (s *frameStack) pop() {
+	s.frames[len(s.frames)-1] = nil
+	s.frames = s.frames[:len(s.frames)-1]
+}
+
+// depth returns the number of frames, for truncate to return to
+func (s *frameStack) depth() int {
+	return len(s.frames)
+}
+
+// truncate leaves every frame entered since the stack had the given depth
+func (s *frameStack) truncate(depth int) {
+	clear(s.frames[depth:])
+	s.frames = s.frames[:depth]
+}
+
+// snapshot returns a copy of the frames
+func (s *frameStack) snapshot() []interface{} {
+	return append([]interface{}(nil), s.frames...)
+}
+
+// activate makes an interpreter's frames roots while it runs code
+func (h *objectHeap) activate(interpreter *Interpreter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.active = append(h.active, interpreter.frames)
+}
+
+// deactivate stops treating an interpreter's frames as roots once it has finished
+func (h *objectHeap) deactivate(interpreter *Interpreter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.active = retain(h.active, func(frames *frameStack) bool { return frames != interpreter.frames })
+}
+
+// activeFrames returns the frames of every interpreter running code: i's own, then
+// the others in the order they started
+func (i *Interpreter) activeFrames() []*frameStack {
+	i.heap.mu.Lock()
+	defer i.heap.mu.Unlock()
+	stacks := []*frameStack{i.frames}
+	for _, frames := range i.heap.active {
+		if frames != i.frames {
+			stacks = append(stacks, frames)
+		}
+	}
+	return stacks
+}
+
+// trackObject registers an object of the given kind
+func trackObject[T any](h *objectHeap, object *T, kind, class string) {
+	h.add(&heapRef[T]{pointer: weak.Make(object), kindName: kind, className: class})
+}
+
+// add appends an entry, first dropping the entries of reclaimed objects once the
+// registry has doubled since the last time, so it stays proportional to the live
+// objects
+func (h *objectHeap) add(object heapObject) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.objects) >= h.nextSweep {
+		h.objects = retain(h.objects, func(object heapObject) bool { return object.target() != nil })
+		h.nextSweep = max(2*len(h.objects), minHeapSweep)
+	}
+	h.objects = append(h.objects, object)
+	h.allocated++
+}
+
+// retain filters a slice in place, keeping the elements keep reports true for
+func retain[T any](elements []T, keep func(T) bool) []T {
+	kept := elements[:0]
+	for _, element := range elements {
+		if keep(element) {
+			kept = append(kept, element)
+		}
+	}
+	clear(elements[len(kept):])
+	return kept
+}
+
+// trackEnvironment registers a captured environment and the ones enclosing it.
+// Only captured environments are registered: any other environment is released
+// when its block or call ends, and registering every call would cost more than
+// the call itself.
+func (h *objectHeap) trackEnvironment(environment *Environment) {
+	for ; environment != nil && environment.values == nil; environment = environment.enclosing {
+		if environment.tracked.Swap(true) {
+			return
+		}
+		trackObject(h, environment, "environment", "")
+	}
+}
+
+// trackFunction registers a function or method and the environment it closes over
+func (h *objectHeap) trackFunction(function *LoxFunction) {
+	trackObject(h, function, "function", "")
+	h.trackEnvironment(function.closure)
+}
+
+// trackClass registers a class, its methods and the environment they close over
+func (h *objectHeap) trackClass(class *LoxClass) {
+	trackObject(h, class, "class", "")
+	for _, methods := range []map[string]*LoxFunction{class.methods, class.staticMethods, class.getters, class.setters} {
+		for _, method := range methods {
+			h.trackFunction(method)
+		}
+	}
+}
+
+// trackInstance registers an instance, holding on to it until it's swept if its
+// class has an onCollect method
+func (h *objectHeap) trackInstance(instance *LoxInstance) {
+	trackObject(h, instance, "instance", instance.class.name)
+	if finalizer := instance.class.FindMethod("onCollect"); finalizer != nil && finalizer.Arity() == 0 {
+		h.mu.Lock()
+		h.finalizable = append(h.finalizable, instance)
+		h.mu.Unlock()
+	}
+}
+
+// trackWeakRef registers a weak reference so a sweep can clear it
+func (h *objectHeap) trackWeakRef(ref *LoxWeakRef) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.weakRefs = append(h.weakRefs, weak.Make(ref))
+}
+
+// collect marks every object reachable from the roots and sweeps the rest
+func (i *Interpreter) collect() {
+	marker := newMarker()
+	marker.mark(i.globals)
+	for _, frames := range i.activeFrames() {
+		marker.markFrames(frames)
+	}
+
+	for _, value := range i.loop.roots() {
+		marker.mark(value)
+	}
+
+	// An instance waiting for onCollect is still used by the call
+	i.heap.finalizeMu.Lock()
+	for _, instance := range i.heap.finalize {
+		marker.mark(instance)
+	}
+	i.heap.finalizeMu.Unlock()
+
+	marker.drain()
+	i.heap.sweep(marker)
+}
+
+// sweep drops the registry entries of unmarked objects, queues onCollect for
+// unmarked instances that have it and clears weak references to unmarked objects
+func (h *objectHeap) sweep(marker *marker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.objects = retain(h.objects, func(object heapObject) bool {
+		return marker.marked(object.target())
+	})
+
+	var swept []*LoxInstance
+	h.finalizable = retain(h.finalizable, func(instance *LoxInstance) bool {
+		if marker.marked(instance) {
+			return true
+		}
+		swept = append(swept, instance)
+		return false
+	})
+	h.finalizeMu.Lock()
+	h.finalize = append(h.finalize, swept...)
+	h.finalizeMu.Unlock()
+
+	h.weakRefs = retain(h.weakRefs, func(pointer weak.Pointer[LoxWeakRef]) bool {
+		ref := pointer.Value()
+		if ref == nil {
+			return false
+		}
+		if target := ref.target(); target == nil || !marker.marked(target) {
+			ref.cleared.Store(true)
+			return false
+		}
+		return true
+	})
+
+	h.collections++
+}
+
+// marker finds the objects reachable from a collection's roots
+type marker struct {
+	seen map[interface{}]struct{}
+	gray []interface{} // marked objects whose references haven't been followed yet
+}
+
+func newMarker() *marker {
+	return &marker{seen: make(map[interface{}]struct{})}
+}
+
+// marked reports whether an object was reached
+func (m *marker) marked(object interface{}) bool {
+	_, ok := m.seen[object]
+	return ok
+}
+
+// mark records that a value was reached, if it's an object that can hold references
+func (m *marker) mark(value interface{}) {
+	switch value := value.(type) {
+	case *Environment:
+		if value == nil {
+			return
+		}
+	case *LoxClass:
+		if value == nil {
+			return
+		}
+	case *LoxFunction, *LoxTrait, *LoxInstance, *LoxList, *LoxMap, *LoxGenerator,
+		*LoxTask, *LoxChannel, *LoxPromise, *NativeFunction:
+	default:
+		return
+	}
+	if m.marked(value) {
+		return
+	}
+	m.seen[value] = struct{}{}
+	m.gray = append(m.gray, value)
+}
+
+// markFrames marks the frames of an interpreter
+func (m *marker) markFrames(frames *frameStack) {
+	for _, frame := range frames.snapshot() {
+		m.mark(frame)
+	}
+}
+
+// drain follows the references of marked objects until everything reachable is marked
+func (m *marker) drain() {
+	for len(m.gray) > 0 {
+		object := m.gray[len(m.gray)-1]
+		m.gray = m.gray[:len(m.gray)-1]
+		m.trace(object)
+	}
+}
+
+// trace marks the objects an object refers to
+func (m *marker) trace(object interface{}) {
+	switch object := object.(type) {
+	case *Environment:
+		object.mu.RLock()
+		for _, value := range object.values {
+			m.mark(value)
+		}
+		for _, value := range object.slots {
+			m.mark(value)
+		}
+		object.mu.RUnlock()
+		m.mark(object.enclosing)
+
+	case *LoxFunction:
+		m.mark(object.closure)
+
+	case *LoxClass:
+		m.mark(object.superclass)
+		for _, methods := range []map[string]*LoxFunction{object.methodTable, object.staticMethods, object.getters, object.setters} {
+			for _, method := range methods {
+				m.mark(method)
+			}
+		}
+		m.mark(object.closure)
+
+	case *LoxTrait:
+		for _, method := range object.methods {
+			m.mark(method)
+		}
+
+	case *LoxInstance:
+		m.mark(object.class)
+		for _, value := range object.fieldSnapshot() {
+			m.mark(value)
+		}
+		for _, fields := range object.privateSnapshot() {
+			for _, value := range fields {
+				m.mark(value)
+			}
+		}
+
+	case *LoxList:
+		for _, element := range object.snapshot() {
+			m.mark(element)
+		}
+
+	case *LoxMap:
+		keys, values := object.entries()
+		for index, key := range keys {
+			m.mark(key)
+			m.mark(values[index])
+		}
+
+	case *LoxGenerator:
+		// A generator that has finished no longer has a body
+		object.mu.Lock()
+		body, pending := object.body, object.pending
+		object.mu.Unlock()
+		if body != nil {
+			m.markFrames(body.frames)
+		}
+		m.mark(pending)
+
+	case *LoxTask:
+		// A running task's frames are marked as an active interpreter
+		object.mu.Lock()
+		result := object.result
+		object.mu.Unlock()
+		m.mark(result)
+
+	case *LoxChannel:
+		for _, value := range object.roots() {
+			m.mark(value)
+		}
+
+	case *LoxPromise:
+		for _, value := range object.roots() {
+			m.mark(value)
+		}
+
+	case *NativeFunction:
+		m.mark(object.receiver)
+	}
+}
+
+// runFinalizers calls onCollect on every swept instance waiting for it. A failing
+// finalizer is reported like any runtime error.
+func (i *Interpreter) runFinalizers() {
+	for !i.hadRuntimeError {
+		i.heap.finalizeMu.Lock()
+		if len(i.heap.finalize) == 0 {
+			i.heap.finalizeMu.Unlock()
+			return
+		}
+		instance := i.heap.finalize[0]
+		i.heap.finalize = i.heap.finalize[1:]
+		i.heap.finalizeMu.Unlock()
+
+		i.callToken = Token{Lexeme: "onCollect"}
+		instance.class.FindMethod("onCollect").invoke(i, instance, nil)
+	}
+}
+
+// heapStats summarizes the registry after a collection
+type heapStats struct {
+	allocated    int
+	live         int
+	collections  int
+	environments int
+	functions    int
+	classes      int
+	instances    map[string]int // live instances by class name
+}
+
+// stats counts the objects in the registry
+func (h *objectHeap) stats() heapStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.objects = retain(h.objects, func(object heapObject) bool { return object.target() != nil })
+
+	stats := heapStats{
+		allocated:   h.allocated,
+		live:        len(h.objects),
+		collections: h.collections,
+		instances:   make(map[string]int),
+	}
+	for _, object := range h.objects {
+		switch object.kind() {
+		case "environment":
+			stats.environments++
+		case "function":
+			stats.functions++
+		case "class":
+			stats.classes++
+		case "instance":
+			stats.instances[object.class()]++
+		}
+	}
+	return stats
+}
+
+// LoxWeakRef refers to an object without keeping it alive
+type LoxWeakRef struct {
+	target  func() interface{} // the object, or nil once Go has reclaimed it
+	cleared atomic.Bool        // set by the sweep that found the object unreachable
+}
+
+// get returns the object, or nil once it has been collected
+func (r *LoxWeakRef) get() interface{} {
+	if r.cleared.Load() {
+		return nil
+	}
+	return r.target()
+}
+
+func (r *LoxWeakRef) String() string {
+	if r.get() == nil {
+		return "<weak ref cleared>"
+	}
+	return "<weak ref>"
+}
+
+// newWeakRef returns a weak reference to an object, or an error if the value isn't
+// an object that can be collected
+func newWeakRef(value interface{}) (*LoxWeakRef, error) {
+	switch object := value.(type) {
+	case *LoxInstance:
+		return &LoxWeakRef{target: weakTarget(weak.Make(object))}, nil
+	case *LoxFunction:
+		return &LoxWeakRef{target: weakTarget(weak.Make(object))}, nil
+	case *LoxClass:
+		return &LoxWeakRef{target: weakTarget(weak.Make(object))}, nil
+	case *LoxList:
+		return &LoxWeakRef{target: weakTarget(weak.Make(object))}, nil
+	case *LoxMap:
+		return &LoxWeakRef{target: weakTarget(weak.Make(object))}, nil
+	}
+	return nil, fmt.Errorf("weakRef() expects an instance, function, class, list or map but got %s.", typeName(value))
+}
+
+// weakTarget returns a function reading a weak pointer, giving an untyped nil once
+// the object is gone
+func weakTarget[T any](pointer weak.Pointer[T]) func() interface{} {
+	return func() interface{} {
+		if object := pointer.Value(); object != nil {
+			return object
+		}
+		return nil
+	}
+}
+
+// weakRefMethod returns the built-in method with the given name bound to the weak
+// reference, or nil
+func weakRefMethod(ref *LoxWeakRef, name string) *NativeFunction {
+	switch name {
+	case "get":
+		// The object, or nil once it has been collected
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return ref.get(), nil
+		})
+	case "alive":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return ref.get() != nil, nil
+		})
+	}
+	return nil
+}
+
+// defineHeapNatives registers the garbage collection natives in the given
+// environment: weakRef(obj), gc(), which collects and runs pending onCollect
+// methods, and gcStats(), which reports the objects still alive.
+func defineHeapNatives(env *Environment) {
+	env.Define("weakRef", NewNativeFunction("weakRef", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		ref, err := newWeakRef(arguments[0])
+		if err != nil {
+			return nil, err
+		}
+		interpreter.heap.trackWeakRef(ref)
+		return ref, nil
+	}))
+
+	env.Define("gc", NewNativeFunction("gc", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		if interpreter.sched.othersRunning() {
+			return nil, fmt.Errorf("gc() can't run while other tasks are running.")
+		}
+		interpreter.collect()
+		interpreter.runFinalizers()
+		return nil, nil
+	}))
+	env.Define("gcStats", NewNativeFunction("gcStats", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		stats := interpreter.heap.stats()
+
+		classes := make([]string, 0, len(stats.instances))
+		for class := range stats.instances {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		instances := NewLoxMap()
+		for _, class := range classes {
+			instances.Set(class, float64(stats.instances[class]))
+		}
+
+		result := NewLoxMap()
+		result.Set("allocated", float64(stats.allocated))
+		result.Set("live", float64(stats.live))
+		result.Set("collected", float64(stats.allocated-stats.live))
+		result.Set("collections", float64(stats.collections))
+		result.Set("environments", float64(stats.environments))
+		result.Set("functions", float64(stats.functions))
+		result.Set("classes", float64(stats.classes))
+		result.Set("instances", instances)
+		return result, nil
+	}))
+}
@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestCollectSweepsCycles(t *testing.T) {
+	source := `
+class Res {
+  init(n) { this.n = n; }
+  onCollect() { print "collected " + str(this.n); }
+}
+var cyc = Res(3);
+cyc.self = cyc;
+var ref = weakRef(cyc);
+cyc = nil;
+gc();
+print gcStats()["instances"];
+print ref.get();
+`
+	want := "collected 3\n{}\nnil\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestCollectKeepsActiveFrames(t *testing.T) {
+	source := `
+class Res {
+  init(n) { this.n = n; }
+  onCollect() { print "collected " + str(this.n); }
+}
+fun work() {
+  var local = Res(1);
+  for (var item in list(Res(2))) {
+    gc();
+    print item.n;
+  }
+  print local.n;
+}
+work();
+fun* hold() { var held = Res(3); yield 0; yield held.n; }
+var generator = hold();
+generator.next();
+gc();
+print generator.next();
+`
+	want := "2\n1\ncollected 1\ncollected 2\n3\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestCollectKeepsValuesInFlight(t *testing.T) {
+	source := `
+class Res {
+  init(n) { this.n = n; }
+  show(unused) { print this.n; }
+  __add(other) { return this.n + other; }
+  onCollect() { print "collected " + str(this.n); }
+}
+fun first(a, b) { print a.n; }
+fun zero() { gc(); return 0; }
+first(Res(1), gc());
+Res(2).show(gc());
+print Res(3) + zero();
+gc();
+`
+	want := "1\ncollected 1\n2\ncollected 2\n3\ncollected 3\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestCollectFailsWhileTasksRun(t *testing.T) {
+	source := `
+fun work() { sleep(50); }
+var task = spawn work();
+gc();
+`
+	_, errors := runFailingScript(t, source, Options{})
+	if want := "gc() can't run while other tasks are running.\n[line 4]\n"; errors != want {
+		t.Errorf("got error %q, want %q", errors, want)
+	}
+}
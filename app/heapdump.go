@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"unsafe"
+)
+
+// A heap dump is a JSON snapshot of the object graph reachable from the same roots
+// a collection marks from: the globals, the frames of every interpreter running
+// code and the callbacks waiting on the event loop. Every object is listed once,
+// with its shallow size in bytes and the path by which it was first reached.
+// Objects are visited breadth first in a fixed order, so each path is a shortest
+// retention path and the same program state always produces the same dump; two
+// dumps taken at different times can be diffed to find what is keeping objects
+// alive.
+//
+// Natives are left out, apart from built-in methods bound to an object.
+
+// heapDump is the document written by heapdump() and --heap-dump
+type heapDump struct {
+	Objects []*dumpObject        `json:"objects"`
+	Totals  map[string]dumpTotal `json:"totals"`
+}
+
+// dumpObject is one object in a heap dump
+type dumpObject struct {
+	ID    int        `json:"id"`
+	Type  string     `json:"type"`
+	Name  string     `json:"name,omitempty"` // functions, classes and the class of an instance
+	Size  int        `json:"size"`
+	Path  string     `json:"path"`
+	Edges []dumpEdge `json:"edges,omitempty"`
+}
+
+// dumpEdge is a reference from one object to another
+type dumpEdge struct {
+	Name string `json:"name"`
+	To   int    `json:"to"`
+}
+
+// dumpTotal sums the objects of one type
+type dumpTotal struct {
+	Count int `json:"count"`
+	Size  int `json:"size"`
+}
+
+// heapWalker builds a heap dump
+type heapWalker struct {
+	ids     map[interface{}]int
+	objects []*dumpObject
+	queue   []interface{}
+}
+
+// heapDump walks the object graph from the roots. The frames of the code taking
+// the dump come first, outermost first, followed by those of other interpreters in
+// the order they started.
+func (i *Interpreter) heapDump() *heapDump {
+	walker := &heapWalker{ids: make(map[interface{}]int)}
+	walker.add(i.globals, "globals")
+	count := 0
+	for _, frames := range i.activeFrames() {
+		for _, value := range frames.snapshot() {
+			walker.root(value, frameName(value, &count))
+		}
+	}
+	for _, value := range i.loop.roots() {
+		walker.root(value, "(event loop)")
+	}
+	for len(walker.queue) > 0 {
+		object := walker.queue[0]
+		walker.queue = walker.queue[1:]
+		walker.visit(object)
+	}
+
+	dump := &heapDump{Objects: walker.objects, Totals: make(map[string]dumpTotal)}
+	for _, object := range walker.objects {
+		total := dump.Totals[object.Type]
+		total.Count++
+		total.Size += object.Size
+		dump.Totals[object.Type] = total
+	}
+	return dump
+}
+
+// heapDumpJSON returns the heap dump as indented JSON. Like a collection, it can't
+// see into tasks running on other goroutines, so it fails while any are.
+func (i *Interpreter) heapDumpJSON() (string, error) {
+	if i.sched.othersRunning() {
+		return "", fmt.Errorf("Can't take a heap dump while other tasks are running.")
+	}
+	data, err := json.MarshalIndent(i.heapDump(), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("Could not write heap dump: %v", err)
+	}
+	return string(data), nil
+}
+
+// isHeapObject reports whether a value is an object the dump lists
+func isHeapObject(value interface{}) bool {
+	switch value := value.(type) {
+	case *Environment, *LoxFunction, *LoxClass, *LoxTrait, *LoxInstance, *LoxList, *LoxMap,
+		*LoxRange, *LoxGenerator, *LoxTask, *LoxChannel, *LoxPromise, *LoxStringBuilder, *LoxWeakRef:
+		return true
+	case *NativeFunction:
+		// Only built-in methods bound to an object
+		return value.receiver != nil
+	}
+	return false
+}
+
+// add lists an object the first time it's reached and returns its id
+func (w *heapWalker) add(object interface{}, path string) int {
+	if id, ok := w.ids[object]; ok {
+		return id
+	}
+	id := len(w.objects) + 1
+	w.ids[object] = id
+	w.objects = append(w.objects, &dumpObject{ID: id, Type: dumpTypeName(object), Path: path})
+	w.queue = append(w.queue, object)
+	return id
+}
+
+// root lists a value found in a frame or on the event loop, if it's an object
+func (w *heapWalker) root(value interface{}, path string) {
+	if isHeapObject(value) {
+		w.add(value, path)
+	}
+}
+
+// frameEdges records the references from the frames of a generator or task
+func (w *heapWalker) frameEdges(from *dumpObject, frames *frameStack) {
+	count := 0
+	for _, value := range frames.snapshot() {
+		w.edge(from, frameName(value, &count), value)
+	}
+}
+
+// frameName names an entry of a frame stack. Environments are numbered in the
+// order they were entered; anything else is a value the code is holding on to,
+// such as the list a for-in loop is iterating over.
+func frameName(value interface{}, count *int) string {
+	if _, ok := value.(*Environment); ok {
+		*count++
+		return fmt.Sprintf("(frame %d)", *count)
+	}
+	return "(held)"
+}
+
+// dumpTypeName names an object's type in a heap dump
+func dumpTypeName(object interface{}) string {
+	if _, ok := object.(*Environment); ok {
+		return "environment"
+	}
+	return typeName(object)
+}
+
+// edge records a reference from the object being visited, if value is an object
+func (w *heapWalker) edge(from *dumpObject, name string, value interface{}) {
+	if !isHeapObject(value) {
+		return
+	}
+	path := from.Path + "." + name
+	if len(name) > 0 && name[0] == '[' {
+		path = from.Path + name
+	}
+	from.Edges = append(from.Edges, dumpEdge{Name: name, To: w.add(value, path)})
+}
+
+// functionEdges records the references to each function in a method table, in name order
+func (w *heapWalker) functionEdges(from *dumpObject, prefix string, functions map[string]*LoxFunction) {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.edge(from, prefix+name, functions[name])
+	}
+}
+
+// visit fills in the size, name and references of an object
+func (w *heapWalker) visit(object interface{}) {
+	node := w.objects[w.ids[object]-1]
+
+	switch object := object.(type) {
+	case *Environment:
+		object.mu.RLock()
+		node.Size = int(unsafe.Sizeof(*object))
+		if object.values != nil {
+			names := make([]string, 0, len(object.values))
+			for name, value := range object.values {
+				switch value := value.(type) {
+				case *ClockNative:
+					continue
+				case *NativeFunction:
+					if value.receiver == nil {
+						continue
+					}
+				}
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			values := make([]interface{}, len(names))
+			for index, name := range names {
+				values[index] = object.values[name]
+				node.Size += mapEntrySize + len(name) + valueSize(values[index])
+			}
+			object.mu.RUnlock()
+			for index, name := range names {
+				w.edge(node, name, values[index])
+			}
+			return
+		}
+		names := append([]string(nil), object.names...)
+		slots := append([]interface{}(nil), object.slots...)
+		object.mu.RUnlock()
+		node.Size += cap(names)*int(unsafe.Sizeof("")) + cap(slots)*interfaceSize
+		for index, name := range names {
+			node.Size += valueSize(slots[index])
+			w.edge(node, name, slots[index])
+		}
+		if object.enclosing != nil {
+			w.edge(node, "(enclosing)", object.enclosing)
+		}
+
+	case *LoxFunction:
+		node.Name = object.declaration.Name.Lexeme
+		node.Size = int(unsafe.Sizeof(*object))
+		w.edge(node, "(closure)", object.closure)
+
+	case *LoxClass:
+		node.Name = object.name
+		node.Size = int(unsafe.Sizeof(*object)) +
+			mapEntrySize*(len(object.methods)+len(object.methodTable)+len(object.staticMethods)+len(object.getters)+len(object.setters))
+		if object.superclass != nil {
+			w.edge(node, "(superclass)", object.superclass)
+		}
+		w.functionEdges(node, "", object.methods)
+		w.functionEdges(node, "(static) ", object.staticMethods)
+		w.functionEdges(node, "(get) ", object.getters)
+		w.functionEdges(node, "(set) ", object.setters)
+		w.edge(node, "(closure)", object.closure)
+
+	case *LoxTrait:
+		node.Name = object.name
+		node.Size = int(unsafe.Sizeof(*object)) + mapEntrySize*len(object.methods)
+		w.functionEdges(node, "", object.methods)
+
+	case *LoxInstance:
+		node.Name = object.class.name
+		node.Size = int(unsafe.Sizeof(*object))
+		fields := object.fieldSnapshot()
+		// Private fields are named after their declaring class, which a subclass
+		// can share a field name with
+		for owner, private := range object.privateSnapshot() {
+			for name, value := range private {
+				fields[name+" ("+owner.name+")"] = value
+			}
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.edge(node, "(class)", object.class)
+		for _, name := range names {
+			node.Size += mapEntrySize + len(name) + valueSize(fields[name])
+			w.edge(node, name, fields[name])
+		}
+
+	case *LoxList:
+		elements := object.snapshot()
+		node.Size = int(unsafe.Sizeof(*object)) + len(elements)*interfaceSize
+		for index, element := range elements {
+			node.Size += valueSize(element)
+			w.edge(node, "["+strconv.Itoa(index)+"]", element)
+		}
+
+	case *LoxMap:
+		keys, values := object.entries()
+		node.Size = int(unsafe.Sizeof(*object)) + len(keys)*interfaceSize
+		for index, key := range keys {
+			value := values[index]
+			node.Size += mapEntrySize + valueSize(key) + valueSize(value)
+			w.edge(node, "(key "+strconv.Itoa(index)+")", key)
+			w.edge(node, "["+dumpKey(key)+"]", value)
+		}
+
+	case *LoxStringBuilder:
+		node.Size = int(unsafe.Sizeof(*object)) + len(object.String())
+
+	case *LoxPromise:
+		node.Size = int(unsafe.Sizeof(*object))
+		object.mu.Lock()
+		settled, value := object.settled, object.outcome.value
+		object.mu.Unlock()
+		if settled {
+			w.edge(node, "(value)", value)
+		}
+
+	case *LoxRange:
+		node.Size = int(unsafe.Sizeof(*object))
+	case *LoxGenerator:
+		// A suspended generator holds the frames its body is partway through
+		node.Name = object.name
+		node.Size = int(unsafe.Sizeof(*object))
+		object.mu.Lock()
+		body, pending, ready := object.body, object.pending, object.ready
+		object.mu.Unlock()
+		if body != nil {
+			w.frameEdges(node, body.frames)
+		}
+		if ready {
+			w.edge(node, "(pending)", pending)
+		}
+
+	case *LoxTask:
+		node.Name = object.name
+		node.Size = int(unsafe.Sizeof(*object))
+		object.mu.Lock()
+		frames, result := object.frames, object.result
+		object.mu.Unlock()
+		if frames != nil {
+			w.frameEdges(node, frames)
+		}
+		w.edge(node, "(result)", result)
+
+	case *LoxChannel:
+		node.Size = int(unsafe.Sizeof(*object))
+		for index, value := range object.roots() {
+			w.edge(node, "["+strconv.Itoa(index)+"]", value)
+		}
+
+	case *NativeFunction:
+		node.Name = object.name
+		node.Size = int(unsafe.Sizeof(*object))
+		w.edge(node, "(receiver)", object.receiver)
+	case *LoxWeakRef:
+		// The target isn't retained, so it isn't an edge
+		node.Size = int(unsafe.Sizeof(*object))
+	}
+}
+
+// Approximate costs of the storage behind a value, in bytes
+const (
+	interfaceSize = int(unsafe.Sizeof(interface{}(nil)))
+	mapEntrySize  = 2*interfaceSize + 8 // key, value and bucket overhead
+)
+
+// valueSize is the size of the data a value keeps outside its slot: the bytes of
+// a string. Objects are counted separately, so they add nothing.
+func valueSize(value interface{}) int {
+	if s, ok := value.(string); ok {
+		return len(s)
+	}
+	return 0
+}
+
+// dumpKey formats a map key for an edge name
+func dumpKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return strconv.Quote(s)
+	}
+	if key == nil {
+		return "nil"
+	}
+	if isHeapObject(key) {
+		return "<" + typeName(key) + ">"
+	}
+	return fmt.Sprint(key)
+}
+
+// defineHeapDumpNatives registers heapdump() in the given environment. It returns
+// the heap dump as a JSON string, which a script can write to a file with writeFile.
+func defineHeapDumpNatives(env *Environment) {
+	env.Define("heapdump", NewNativeFunction("heapdump", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return interpreter.heapDumpJSON()
+	}))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dumpPaths returns the path of every instance in a printed heap dump, by class
+func dumpPaths(t *testing.T, output string) map[string][]string {
+	t.Helper()
+	var dump heapDump
+	if err := json.Unmarshal([]byte(output), &dump); err != nil {
+		t.Fatalf("dump isn't valid JSON: %v\n%s", err, output)
+	}
+	paths := make(map[string][]string)
+	for _, object := range dump.Objects {
+		if object.Type == "instance" {
+			paths[object.Name] = append(paths[object.Name], object.Path)
+		}
+	}
+	return paths
+}
+
+func TestHeapDumpWalksCallerFrames(t *testing.T) {
+	source := `
+class Big {}
+var dump;
+fun g() { dump = heapdump(); }
+fun f() { var held = Big(); g(); }
+f();
+print dump;
+`
+	paths := dumpPaths(t, runScript(t, source, Options{}))
+	if got := paths["Big"]; len(got) != 1 || got[0] != "(frame 2).held" {
+		t.Errorf("got Big instances at %q, want one at (frame 2).held", got)
+	}
+}
+
+func TestHeapDumpFollowsGeneratorsAndBoundMethods(t *testing.T) {
+	source := `
+class Kept {}
+class Buffered {}
+class Pushed {}
+fun* gen() { var kept = Kept(); yield 1; yield 2; }
+var it = gen();
+it.next();
+var c = channel(1);
+c.send(Buffered());
+var push = list(Pushed()).push;
+print heapdump();
+`
+	paths := dumpPaths(t, runScript(t, source, Options{}))
+	want := map[string]string{
+		"Kept":     "globals.it.(frame 1).kept",
+		"Buffered": "globals.c[0]",
+		"Pushed":   "globals.push.(receiver)[0]",
+	}
+	for class, path := range want {
+		if got := paths[class]; len(got) != 1 || got[0] != path {
+			t.Errorf("got %s instances at %q, want one at %s", class, got, path)
+		}
+	}
+}
+
+func TestHeapDumpListsEachObjectOnce(t *testing.T) {
+	source := `
+class Node { init(next) { this.next = next; } }
+var a = Node(nil);
+var b = Node(a);
+a.next = b;
+var w = weakRef(Node(nil));
+print heapdump();
+`
+	var dump heapDump
+	if err := json.Unmarshal([]byte(runScript(t, source, Options{})), &dump); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cycle is listed once per object, and the weakly held node not at all
+	byPath := make(map[string]*dumpObject)
+	for index, object := range dump.Objects {
+		if object.ID != index+1 {
+			t.Errorf("object %d has id %d", index+1, object.ID)
+		}
+		byPath[object.Path] = object
+	}
+	if total := dump.Totals["instance"]; total.Count != 2 {
+		t.Errorf("got %d instances, want 2", total.Count)
+	}
+	a, b := byPath["globals.a"], byPath["globals.b"]
+	if a == nil || b == nil {
+		t.Fatalf("a and b weren't reached from the globals: %v", byPath)
+	}
+	if edges := a.Edges; len(edges) != 2 || edges[1].Name != "next" || edges[1].To != b.ID {
+		t.Errorf("got edges %v from a, want (class) and next to %d", edges, b.ID)
+	}
+	if weak := byPath["globals.w"]; weak == nil || weak.Type != "weakref" || len(weak.Edges) != 0 {
+		t.Errorf("got %+v for the weak reference, want one with no edges", weak)
+	}
+}
+
+func TestHeapDumpErrors(t *testing.T) {
+	// A dump can't see into a task that's still running
+	_, errors := runFailingScript(t, `
+fun wait() { sleep(50); }
+var task = spawn wait();
+heapdump();
+`, Options{})
+	if want := "Can't take a heap dump while other tasks are running.\n[line 4]\n"; errors != want {
+		t.Errorf("got error %q, want %q", errors, want)
+	}
+
+	// The dump written after a runtime error still shows what the script held
+	interpreter, _, _ := interpretScript(t, "class Leak {}\nvar kept = Leak();\nnil();", Options{})
+	file := filepath.Join(t.TempDir(), "dump.json")
+	if err := writeHeapDump(interpreter, file); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths := dumpPaths(t, string(data)); len(paths["Leak"]) != 1 || paths["Leak"][0] != "globals.kept" {
+		t.Errorf("got Leak instances at %q, want one at globals.kept", paths["Leak"])
+	}
+
+	err = writeHeapDump(interpreter, filepath.Join(t.TempDir(), "missing", "dump.json"))
+	if err == nil || !strings.HasPrefix(err.Error(), "Error writing heap dump:") {
+		t.Errorf("got error %v writing to a missing directory", err)
+	}
+}
@@ -2,42 +2,143 @@ package main
 
 import (
 	"fmt"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Options configures the capabilities granted to scripts run by an interpreter.
+// The zero value is fully sandboxed.
+type Options struct {
+	AllowFS  bool     // enables the file system natives
+	AllowEnv bool     // enables reading environment variables
+	Args     []string // command-line arguments returned by args()
+	Clock    Clock    // time source for the time natives; defaults to the system clock
+	Seed     *int64   // seed for the random natives; nil seeds from the current time
+}
+
 // Interpreter evaluates expressions
 type Interpreter struct {
 	hadRuntimeError bool
 	globals         *Environment
 	environment     *Environment
-	locals          map[Expr]int
+	locals          map[Expr]binding
+	privateAccess   map[Expr]*Class
+	callToken       Token
+	options         Options
+	startTime       time.Time
+	random          *rand.Rand
+	regexCache      *sync.Map            // pattern -> *regexp.Regexp, shared with forked interpreters
+	coroutine       *coroutine           // set while running an async function's body
+	errorSink       errorSink            // receives runtime errors instead of stderr when set
+	loop            *eventLoop           // shared with forked interpreters
+	concat          concatBuffer         // reused by repeated string concatenation
+	heap            *objectHeap          // shared with forked interpreters
+	sched           *scheduler           // shared with forked interpreters
+	frames          *frameStack          // roots for garbage collection
+	printing        map[interface{}]bool // lists and maps Stringify is partway through
+	lastIOError     interface{}          // message of the last failed file system native, or nil
+	exit            *exitStatus          // shared with forked interpreters
+}
+
+// errorSink receives the runtime errors of code whose failure is reported elsewhere,
+// such as a spawned task or an async function
+type errorSink interface {
+	fail(message string, line int)
 }
 
 func NewInterpreter() *Interpreter {
+	return NewInterpreterWithOptions(Options{})
+}
+
+func NewInterpreterWithOptions(options Options) *Interpreter {
+	if options.Clock == nil {
+		options.Clock = systemClock{}
+	}
+
 	globals := NewEnvironment()
 
 	// Define native functions
 	globals.Define("clock", &ClockNative{})
+	defineMathNatives(globals)
+	defineStringNatives(globals)
+	defineCollectionNatives(globals)
+	defineJSONNatives(globals)
+	defineTimeNatives(globals)
+	defineRandomNatives(globals)
+	defineRegexNatives(globals)
+	defineReflectionNatives(globals)
+	defineConcurrencyNatives(globals)
+	defineAsyncNatives(globals)
+	defineStringBuilderNatives(globals)
+	defineHeapNatives(globals)
+	defineHeapDumpNatives(globals)
+
+	seed := time.Now().UnixNano()
+	if options.Seed != nil {
+		seed = *options.Seed
+	}
+	defineSystemNatives(globals)
 
-	return &Interpreter{
+	interpreter := &Interpreter{
 		hadRuntimeError: false,
 		globals:         globals,
 		environment:     globals,
-		locals:          make(map[Expr]int),
+		locals:          make(map[Expr]binding),
+		privateAccess:   make(map[Expr]*Class),
+		options:         options,
+		startTime:       options.Clock.Now(),
+		random:          rand.New(newLockedSource(seed)),
+		regexCache:      &sync.Map{},
+		loop:            newEventLoop(),
+		heap:            newObjectHeap(),
+		sched:           newScheduler(),
+		frames:          newFrameStack(globals),
+		exit:            &exitStatus{},
 	}
+	interpreter.heap.activate(interpreter)
+	return interpreter
+}
+
+// fork returns an interpreter that shares this one's globals, resolution data and
+// options but has its own execution state, so code can run on another goroutine
+func (i *Interpreter) fork(environment *Environment) *Interpreter {
+	child := *i
+	child.environment = environment
+	child.hadRuntimeError = false
+	child.coroutine = nil
+	child.concat = concatBuffer{}
+	child.frames = newFrameStack(environment)
+	child.printing = nil
+	child.lastIOError = nil
+	return &child
 }
 
-// resolve stores the resolved depth for a variable
-func (i *Interpreter) resolve(expr Expr, depth int) {
-	i.locals[expr] = depth
+// binding locates a resolved local variable: the number of environments to walk
+// up, and the variable's slot in that environment
+type binding struct {
+	depth int
+	slot  int
+}
+
+// resolve stores the resolved depth and slot for a variable
+func (i *Interpreter) resolve(expr Expr, depth int, slot int) {
+	i.locals[expr] = binding{depth: depth, slot: slot}
+}
+
+// resolvePrivate records the class whose body contains a private member access
+func (i *Interpreter) resolvePrivate(expr Expr, class *Class) {
+	i.privateAccess[expr] = class
 }
 
 // lookUpVariable looks up a variable using the resolved depth if available
 func (i *Interpreter) lookUpVariable(name Token, expr Expr) interface{} {
-	if distance, ok := i.locals[expr]; ok {
-		return i.environment.GetAt(distance, name.Lexeme)
+	if local, ok := i.locals[expr]; ok {
+		return i.environment.GetAt(local.depth, local.slot)
 	} else {
 		// Global variable - look up in globals environment
 		value, err := i.globals.Get(name)
@@ -54,16 +155,71 @@ func (i *Interpreter) Evaluate(expr Expr) interface{} {
 	return expr.Accept(i)
 }
 
-// Execute executes a statement
-func (i *Interpreter) Execute(stmt Stmt) {
-	stmt.Accept(i)
+// evaluateIn evaluates an expression with the given environment as the current one
+func (i *Interpreter) evaluateIn(expr Expr, environment *Environment) interface{} {
+	previous := i.environment
+	i.frames.push(environment)
+	defer func() {
+		i.environment = previous
+		i.frames.pop()
+	}()
+
+	i.environment = environment
+	return i.Evaluate(expr)
+}
+
+// completionKind says why a statement stopped before running to its end
+type completionKind int
+
+const (
+	completeReturn completionKind = iota
+	completeBreak
+	completeContinue
+	completeError
+	completeTailCall
+)
+
+// completion is the result of executing a statement abruptly. A statement that
+// finishes normally returns nil, so only return statements allocate.
+type completion struct {
+	kind  completionKind
+	value interface{} // the value of a return statement
+
+	// A tail call still to be made by the returning function
+	function  *LoxFunction
+	this      *LoxInstance // set when the function is an unbound method
+	arguments []interface{}
+}
+
+var (
+	breakCompletion    = &completion{kind: completeBreak}
+	continueCompletion = &completion{kind: completeContinue}
+	errorCompletion    = &completion{kind: completeError}
+)
+
+// Execute executes a statement and returns how it completed
+func (i *Interpreter) Execute(stmt Stmt) *completion {
+	result, _ := stmt.Accept(i).(*completion)
+	if result == nil && i.exit.requested.Load() {
+		// exit() was called, perhaps by another task, so stop as if from an error
+		i.hadRuntimeError = true
+	}
+	if result == nil && i.hadRuntimeError {
+		return errorCompletion
+	}
+	return result
 }
 
 // InterpretStatements interprets a list of statements
 func (i *Interpreter) InterpretStatements(statements []Stmt) {
+	defer func() {
+		if r := recover(); r != nil {
+			i.internalError(r)
+		}
+	}()
+
 	for _, stmt := range statements {
-		i.Execute(stmt)
-		if i.hadRuntimeError {
+		if i.Execute(stmt) != nil {
 			break
 		}
 	}
@@ -73,8 +229,14 @@ func (i *Interpreter) InterpretStatements(statements []Stmt) {
 func (i *Interpreter) VisitPrintStmt(stmt *Print) interface{} {
 	value := i.Evaluate(stmt.Expression)
 	if !i.hadRuntimeError {
+		// Stringifying can fail when it calls a toString method, which may in
+		// turn collect garbage while the value is held only here
+		i.frames.push(value)
 		output := i.Stringify(value)
-		fmt.Println(output)
+		i.frames.pop()
+		if !i.hadRuntimeError {
+			fmt.Println(output)
+		}
 	}
 	return nil
 }
@@ -102,6 +264,7 @@ func (i *Interpreter) VisitVarStmt(stmt *Var) interface{} {
 func (i *Interpreter) VisitFunctionStmt(stmt *Function) interface{} {
 	// Capture the current environment as the closure
 	function := NewLoxFunction(stmt, i.environment)
+	i.heap.trackFunction(function)
 	i.environment.Define(stmt.Name.Lexeme, function)
 	return nil
 }
@@ -123,6 +286,21 @@ func (i *Interpreter) VisitClassStmt(stmt *Class) interface{} {
 		}
 	}
 
+	// Evaluate mixed-in traits
+	traits := []*LoxTrait{}
+	for _, traitExpr := range stmt.Traits {
+		traitValue := i.Evaluate(traitExpr)
+		if i.hadRuntimeError {
+			return nil
+		}
+		trait, ok := traitValue.(*LoxTrait)
+		if !ok {
+			i.runtimeError(traitExpr.Name, "Can only mix in traits.")
+			return nil
+		}
+		traits = append(traits, trait)
+	}
+
 	// Define class name in current environment (before methods)
 	i.environment.Define(stmt.Name.Lexeme, nil)
 
@@ -143,7 +321,26 @@ func (i *Interpreter) VisitClassStmt(stmt *Class) interface{} {
 		methods[method.Name.Lexeme] = function
 	}
 
+	if !i.mixInTraits(stmt, traits, methods) {
+		if superclass != nil {
+			i.environment = i.environment.enclosing
+		}
+		return nil
+	}
+
 	class := NewLoxClass(stmt.Name.Lexeme, superclass, methods)
+	class.declaration = stmt
+	class.closure = i.environment
+	for _, method := range stmt.ClassMethods {
+		class.staticMethods[method.Name.Lexeme] = NewLoxFunction(method, i.environment)
+	}
+	for _, getter := range stmt.Getters {
+		class.getters[getter.Name.Lexeme] = NewLoxFunction(getter, i.environment)
+	}
+	for _, setter := range stmt.Setters {
+		class.setters[setter.Name.Lexeme] = NewLoxFunction(setter, i.environment)
+	}
+	i.heap.trackClass(class)
 
 	// Pop super environment if we created one
 	if superclass != nil {
@@ -154,21 +351,101 @@ func (i *Interpreter) VisitClassStmt(stmt *Class) interface{} {
 	return nil
 }
 
+// mixInTraits copies trait methods into a class's method table. Methods the class
+// defines itself take precedence; two traits providing the same method the class
+// doesn't override is an error. Each trait's methods are mixed in in name order, so
+// a class with several conflicts always reports the same one.
+func (i *Interpreter) mixInTraits(stmt *Class, traits []*LoxTrait, methods map[string]*LoxFunction) bool {
+	providers := make(map[string]*LoxTrait)
+	for index, trait := range traits {
+		names := make([]string, 0, len(trait.methods))
+		for name := range trait.methods {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			method := trait.methods[name]
+			if _, ok := methods[name]; ok && providers[name] == nil {
+				// Overridden by the class itself
+				continue
+			}
+			if other, ok := providers[name]; ok {
+				i.runtimeError(stmt.Traits[index].Name, fmt.Sprintf("Method '%s' is provided by both traits %s and %s; override it in %s.", name, other.name, trait.name, stmt.Name.Lexeme))
+				return false
+			}
+			providers[name] = trait
+			methods[name] = method
+		}
+	}
+	return true
+}
+
+// VisitTraitStmt executes a trait declaration
+func (i *Interpreter) VisitTraitStmt(stmt *Trait) interface{} {
+	methods := make(map[string]*LoxFunction)
+	for _, method := range stmt.Methods {
+		function := NewLoxFunction(method, i.environment)
+		function.isInitializer = method.Name.Lexeme == "init"
+		methods[method.Name.Lexeme] = function
+	}
+
+	i.environment.Define(stmt.Name.Lexeme, NewLoxTrait(stmt.Name.Lexeme, methods))
+	return nil
+}
+
 // VisitReturnStmt executes a return statement
 func (i *Interpreter) VisitReturnStmt(stmt *Return) interface{} {
+	if stmt.IsTailCall {
+		return i.tailCall(stmt.Value.(*Call))
+	}
+
 	var value interface{}
 	if stmt.Value != nil {
 		value = i.Evaluate(stmt.Value)
+		if i.hadRuntimeError {
+			return errorCompletion
+		}
 	}
 
-	// Throw the return value to be caught by the function call
-	panic(&ReturnValue{Value: value})
+	return &completion{kind: completeReturn, value: value}
+}
+
+// tailCall evaluates the call in a return statement in tail position. A call to a
+// Lox function isn't made here: it is handed back to the returning function's
+// LoxFunction.call, which runs it in place of the returning function so that the
+// Go stack doesn't grow. Other callables are called as usual.
+func (i *Interpreter) tailCall(expr *Call) *completion {
+	defer i.frames.truncate(i.frames.depth())
+	function, this, arguments, ok := i.prepareCall(expr)
+	if !ok {
+		return errorCompletion
+	}
+
+	i.callToken = expr.Paren
+	if loxFunction, ok := function.(*LoxFunction); ok {
+		return &completion{kind: completeTailCall, function: loxFunction, this: this, arguments: arguments}
+	}
+
+	value := function.Call(i, arguments)
+	if i.hadRuntimeError {
+		return errorCompletion
+	}
+	return &completion{kind: completeReturn, value: value}
+}
+
+// VisitBreakStmt executes a break statement
+func (i *Interpreter) VisitBreakStmt(stmt *Break) interface{} {
+	return breakCompletion
+}
+
+// VisitContinueStmt executes a continue statement
+func (i *Interpreter) VisitContinueStmt(stmt *Continue) interface{} {
+	return continueCompletion
 }
 
 // VisitBlockStmt executes a block statement
 func (i *Interpreter) VisitBlockStmt(stmt *Block) interface{} {
-	i.executeBlock(stmt.Statements, NewEnclosedEnvironment(i.environment))
-	return nil
+	return i.executeBlock(stmt.Statements, NewEnclosedEnvironment(i.environment))
 }
 
 // VisitIfStmt executes an if statement
@@ -180,9 +457,9 @@ func (i *Interpreter) VisitIfStmt(stmt *If) interface{} {
 	}
 
 	if i.isTruthy(condition) {
-		i.Execute(stmt.ThenBranch)
+		return i.Execute(stmt.ThenBranch)
 	} else if stmt.ElseBranch != nil {
-		i.Execute(stmt.ElseBranch)
+		return i.Execute(stmt.ElseBranch)
 	}
 
 	return nil
@@ -201,30 +478,149 @@ func (i *Interpreter) VisitWhileStmt(stmt *While) interface{} {
 			break
 		}
 
-		i.Execute(stmt.Body)
+		if result := i.Execute(stmt.Body); result != nil {
+			if result.kind == completeBreak {
+				break
+			}
+			if result.kind != completeContinue {
+				return result
+			}
+		}
+
+		if stmt.Increment != nil {
+			i.Evaluate(stmt.Increment)
+			if i.hadRuntimeError {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// VisitForInStmt executes a for-in loop. Each iteration runs in a fresh environment
+// so closures created in the body capture that iteration's value.
+func (i *Interpreter) VisitForInStmt(stmt *ForIn) interface{} {
+	iterable := i.Evaluate(stmt.Iterable)
+	if i.hadRuntimeError {
+		return nil
+	}
+
+	// The iterable, and the iterator an instance's iterator() returns, may be
+	// reachable from nothing else while the loop runs
+	defer i.frames.truncate(i.frames.depth())
+	i.frames.push(iterable)
+
+	next := i.iterator(stmt.Name, iterable)
+	if next == nil {
+		return nil
+	}
 
+	for {
+		value, ok := next()
+		if !ok || i.hadRuntimeError {
+			return nil
+		}
+
+		environment := newLocalEnvironment(i.environment, 1)
+		environment.Define(stmt.Name.Lexeme, value)
+		if result := i.executeBlock([]Stmt{stmt.Body}, environment); result != nil {
+			if result.kind == completeBreak {
+				return nil
+			}
+			if result.kind != completeContinue {
+				return result
+			}
+		}
+	}
+}
+
+// iterator returns a function producing the successive values of an iterable, or
+// nil after reporting an error if the value can't be iterated. Lists, maps (over
+// their keys), strings (over their characters) and ranges are built in; instances
+// take part by defining iterator(), which returns an object with hasNext() and next()
+// or any value that can itself be iterated.
+func (i *Interpreter) iterator(token Token, iterable interface{}) func() (interface{}, bool) {
+	switch value := iterable.(type) {
+	case *LoxList:
+		return value.iteratorFunc()
+	case *LoxMap:
+		keys, _ := value.entries()
+		return NewLoxList(keys).iteratorFunc()
+	case string:
+		chars := []interface{}{}
+		for _, r := range value {
+			chars = append(chars, string(r))
+		}
+		return NewLoxList(chars).iteratorFunc()
+	case *LoxRange:
+		return value.iterator()
+	case *LoxGenerator:
+		return func() (interface{}, bool) {
+			return value.Next(i)
+		}
+	case *LoxChannel:
+		// Receives until the channel is closed and drained
+		return func() (interface{}, bool) {
+			element, ok, err := value.Receive()
+			if err != nil {
+				i.runtimeError(token, err.Error())
+				return nil, false
+			}
+			return element, ok
+		}
+	case *LoxInstance:
+		method := value.class.FindMethod("iterator")
+		if method == nil || method.Arity() != 0 {
+			break
+		}
+		result := method.Bind(value).Call(i, nil)
+		i.frames.push(result)
 		if i.hadRuntimeError {
 			return nil
 		}
+		// iterator() can hand back any other iterable, such as a generator
+		iter, ok := result.(*LoxInstance)
+		if !ok {
+			return i.iterator(token, result)
+		}
+		hasNext, hasNextOk := iter.Get(i, Token{Type: IDENTIFIER, Lexeme: "hasNext", Line: token.Line})
+		nextValue, nextOk := iter.Get(i, Token{Type: IDENTIFIER, Lexeme: "next", Line: token.Line})
+		hasNextFn, hasNextCallable := hasNext.(LoxCallable)
+		nextFn, nextCallable := nextValue.(LoxCallable)
+		if !hasNextOk || !nextOk || !hasNextCallable || !nextCallable || hasNextFn.Arity() != 0 || nextFn.Arity() != 0 {
+			i.runtimeError(token, "Iterator must have hasNext() and next() methods.")
+			return nil
+		}
+		return func() (interface{}, bool) {
+			if !i.isTruthy(hasNextFn.Call(i, nil)) || i.hadRuntimeError {
+				return nil, false
+			}
+			return nextFn.Call(i, nil), true
+		}
 	}
 
+	i.runtimeError(token, "Can only iterate over lists, maps, strings, ranges, generators, channels and iterable instances.")
 	return nil
 }
 
-// executeBlock executes a list of statements in a new environment
-func (i *Interpreter) executeBlock(statements []Stmt, environment *Environment) {
+// executeBlock executes a list of statements in a new environment, stopping at the
+// first one that completes abruptly
+func (i *Interpreter) executeBlock(statements []Stmt, environment *Environment) *completion {
 	previous := i.environment
+	i.frames.push(environment)
 	defer func() {
 		i.environment = previous
+		i.frames.pop()
 	}()
 
 	i.environment = environment
 	for _, stmt := range statements {
-		i.Execute(stmt)
-		if i.hadRuntimeError {
-			break
+		if result := i.Execute(stmt); result != nil {
+			return result
 		}
 	}
+	return nil
 }
 
 // VisitVariableExpr evaluates a variable expression
@@ -239,11 +635,11 @@ func (i *Interpreter) VisitThisExpr(expr *This) interface{} {
 
 // VisitSuperExpr evaluates the super keyword
 func (i *Interpreter) VisitSuperExpr(expr *Super) interface{} {
-	distance := i.locals[expr]
-	superclass := i.environment.GetAt(distance, "super").(*LoxClass)
+	local := i.locals[expr]
+	superclass := i.environment.GetAt(local.depth, local.slot).(*LoxClass)
 
-	// Get "this" which is one level closer than "super"
-	object := i.environment.GetAt(distance-1, "this").(*LoxInstance)
+	// Get "this" which is the only slot one level closer than "super"
+	object := i.environment.GetAt(local.depth-1, 0).(*LoxInstance)
 
 	method := superclass.FindMethod(expr.Method.Lexeme)
 	if method == nil {
@@ -259,8 +655,8 @@ func (i *Interpreter) VisitAssignmentExpr(expr *Assignment) interface{} {
 	value := i.Evaluate(expr.Value)
 
 	if !i.hadRuntimeError {
-		if distance, ok := i.locals[expr]; ok {
-			i.environment.AssignAt(distance, expr.Name, value)
+		if local, ok := i.locals[expr]; ok {
+			i.environment.AssignAt(local.depth, local.slot, value)
 		} else {
 			// Global variable - assign in globals environment
 			err := i.globals.Assign(expr.Name, value)
@@ -300,36 +696,133 @@ func (i *Interpreter) VisitLogicalExpr(expr *Logical) interface{} {
 
 // VisitCallExpr evaluates a function call expression
 func (i *Interpreter) VisitCallExpr(expr *Call) interface{} {
-	callee := i.Evaluate(expr.Callee)
+	defer i.frames.truncate(i.frames.depth())
+	function, this, arguments, ok := i.prepareCall(expr)
+	if !ok {
+		return nil
+	}
+
+	// Remember the call site so native functions can report errors against it
+	i.callToken = expr.Paren
 
+	// Call the function
+	if this != nil {
+		return function.(*LoxFunction).invoke(i, this, arguments)
+	}
+	return function.Call(i, arguments)
+}
+
+// prepareCall evaluates the callee and arguments of a call and checks that the call
+// is valid, reporting false after a runtime error. For a call of the form
+// obj.name(...) where name is a method of an instance, it returns the unbound
+// method along with the instance, so the caller can invoke the method directly
+// instead of first creating a bound method object. The evaluated values are pushed
+// on the frames, which the caller truncates once the call is made.
+func (i *Interpreter) prepareCall(expr *Call) (LoxCallable, *LoxInstance, []interface{}, bool) {
+	get, ok := expr.Callee.(*Get)
+	if !ok || isPrivateName(get.Name) {
+		function, arguments, ok := i.evaluateCall(expr)
+		return function, nil, arguments, ok
+	}
+
+	object := i.Evaluate(get.Object)
 	if i.hadRuntimeError {
+		return nil, nil, nil, false
+	}
+	i.frames.push(object)
+
+	instance, ok := object.(*LoxInstance)
+	var method *LoxFunction
+	if ok {
+		method = i.lookUpMethod(get, instance)
+	}
+
+	var callee interface{} = method
+	if method == nil {
+		instance = nil
+		callee = i.getProperty(get, object)
+		if i.hadRuntimeError {
+			return nil, nil, nil, false
+		}
+	}
+
+	function, arguments, ok := i.checkCall(expr, callee)
+	return function, instance, arguments, ok
+}
+
+// lookUpMethod returns the method a property access on instance resolves to, or nil
+// if it names a field, a getter or nothing. Classes can't change once created, so
+// the method found for a class is cached on the access site, and instances of the
+// same class hitting it again skip the lookup.
+func (i *Interpreter) lookUpMethod(get *Get, instance *LoxInstance) *LoxFunction {
+	// Fields belong to the instance and shadow methods, so they can't be cached
+	if _, ok := instance.field(get.Name.Lexeme); ok {
 		return nil
 	}
 
+	if cache := get.cache.Load(); cache != nil && cache.class == instance.class {
+		return cache.method
+	}
+
+	if instance.class.FindGetter(get.Name.Lexeme) != nil {
+		return nil
+	}
+	method := instance.class.FindMethod(get.Name.Lexeme)
+	if method != nil {
+		get.cache.Store(&methodCache{class: instance.class, method: method})
+	}
+	return method
+}
+
+// methodCache is the inline cache of a property access site: the method the
+// property resolved to on the last class seen there
+type methodCache struct {
+	class  *LoxClass
+	method *LoxFunction
+}
+
+// evaluateCall evaluates the callee and arguments of a call and checks that the call
+// is valid, reporting false after a runtime error
+func (i *Interpreter) evaluateCall(expr *Call) (LoxCallable, []interface{}, bool) {
+	callee := i.Evaluate(expr.Callee)
+
+	if i.hadRuntimeError {
+		return nil, nil, false
+	}
+	i.frames.push(callee)
+
+	return i.checkCall(expr, callee)
+}
+
+// checkCall evaluates the arguments of a call to an already evaluated callee and
+// checks that the call is valid, reporting false after a runtime error. Each
+// argument is pushed on the frames, as the ones after it may collect garbage.
+func (i *Interpreter) checkCall(expr *Call, callee interface{}) (LoxCallable, []interface{}, bool) {
 	// Evaluate arguments
-	arguments := []interface{}{}
+	arguments := make([]interface{}, 0, len(expr.Arguments))
 	for _, arg := range expr.Arguments {
-		arguments = append(arguments, i.Evaluate(arg))
+		argument := i.Evaluate(arg)
 		if i.hadRuntimeError {
-			return nil
+			return nil, nil, false
 		}
+		i.frames.push(argument)
+		arguments = append(arguments, argument)
 	}
 
 	// Check if callee is actually callable
 	function, ok := callee.(LoxCallable)
 	if !ok {
 		i.runtimeError(expr.Paren, "Can only call functions and classes.")
-		return nil
+		return nil, nil, false
 	}
 
-	// Check arity
-	if len(arguments) != function.Arity() {
+	// Check arity (natives with a negative arity accept any number of arguments)
+	if function.Arity() >= 0 && len(arguments) != function.Arity() {
 		i.runtimeError(expr.Paren, fmt.Sprintf("Expected %d arguments but got %d.", function.Arity(), len(arguments)))
-		return nil
+		return nil, nil, false
 	}
 
-	// Call the function
-	return function.Call(i, arguments)
+	return function, arguments, true
 }
 
 // VisitGetExpr evaluates a property access expression
@@ -339,17 +832,179 @@ func (i *Interpreter) VisitGetExpr(expr *Get) interface{} {
 		return nil
 	}
 
+	return i.getProperty(expr, object)
+}
+
+// getProperty looks up the property accessed by expr on an evaluated object
+func (i *Interpreter) getProperty(expr *Get, object interface{}) interface{} {
+	if isPrivateName(expr.Name) {
+		return i.getPrivate(expr, object)
+	}
+
 	// Check if the object is an instance
 	if instance, ok := object.(*LoxInstance); ok {
-		value := instance.Get(expr.Name)
-		if value == nil {
+		if method := i.lookUpMethod(expr, instance); method != nil {
+			return method.Bind(instance)
+		}
+		value, ok := instance.Get(i, expr.Name)
+		if !ok {
 			i.runtimeError(expr.Name, fmt.Sprintf("Undefined property '%s'.", expr.Name.Lexeme))
 			return nil
 		}
 		return value
 	}
 
-	i.runtimeError(expr.Name, "Only instances have properties.")
+	// Classes expose their static methods
+	if class, ok := object.(*LoxClass); ok {
+		method := class.FindStaticMethod(expr.Name.Lexeme)
+		if method == nil {
+			i.runtimeError(expr.Name, fmt.Sprintf("Undefined property '%s'.", expr.Name.Lexeme))
+			return nil
+		}
+		return method
+	}
+
+	// Built-in types expose their methods
+	var method *NativeFunction
+	switch value := object.(type) {
+	case string:
+		method = stringMethod(value, expr.Name.Lexeme)
+	case *LoxList:
+		method = listMethod(value, expr.Name.Lexeme)
+	case *LoxMap:
+		method = mapMethod(value, expr.Name.Lexeme)
+	case *LoxGenerator:
+		method = generatorMethod(value, expr.Name.Lexeme)
+	case *LoxTask:
+		method = taskMethod(value, expr.Name.Lexeme)
+	case *LoxChannel:
+		method = channelMethod(value, expr.Name.Lexeme)
+	case *LoxPromise:
+		method = promiseMethod(value, expr.Name.Lexeme)
+	case *LoxStringBuilder:
+		method = stringBuilderMethod(value, expr.Name.Lexeme)
+	case *LoxWeakRef:
+		method = weakRefMethod(value, expr.Name.Lexeme)
+	default:
+		i.runtimeError(expr.Name, "Only instances have properties.")
+		return nil
+	}
+
+	if method == nil {
+		i.runtimeError(expr.Name, fmt.Sprintf("Undefined property '%s'.", expr.Name.Lexeme))
+		return nil
+	}
+	method.receiver = object
+	return method
+}
+
+// isPrivateName reports whether a property name refers to a private member
+func isPrivateName(name Token) bool {
+	return name.Type == PRIVATE_IDENTIFIER
+}
+
+// privateOwner finds the class that declared the private member accessed by expr.
+// The access is only allowed when the object's class is, or inherits from, the class
+// whose body contains the access.
+func (i *Interpreter) privateOwner(expr Expr, name Token, class *LoxClass) *LoxClass {
+	var owner *LoxClass
+	if declaration, ok := i.privateAccess[expr]; ok {
+		owner = class.ancestor(declaration)
+	}
+	if owner == nil {
+		i.runtimeError(name, fmt.Sprintf("Can't access private member '%s' of %s from here.", name.Lexeme, class.name))
+	}
+	return owner
+}
+
+// getPrivate evaluates a private property access on an instance or class
+func (i *Interpreter) getPrivate(expr *Get, object interface{}) interface{} {
+	switch value := object.(type) {
+	case *LoxInstance:
+		owner := i.privateOwner(expr, expr.Name, value.class)
+		if owner == nil {
+			return nil
+		}
+		if field, ok := value.privateField(owner, expr.Name.Lexeme); ok {
+			return field
+		}
+		// Private methods are looked up on the declaring class only, so a subclass
+		// can't override them
+		if getter, ok := owner.getters[expr.Name.Lexeme]; ok {
+			return getter.Bind(value).Call(i, nil)
+		}
+		if method, ok := owner.methods[expr.Name.Lexeme]; ok {
+			return method.Bind(value)
+		}
+	case *LoxClass:
+		owner := i.privateOwner(expr, expr.Name, value)
+		if owner == nil {
+			return nil
+		}
+		if method, ok := owner.staticMethods[expr.Name.Lexeme]; ok {
+			return method
+		}
+	default:
+		i.runtimeError(expr.Name, "Only instances have properties.")
+		return nil
+	}
+
+	i.runtimeError(expr.Name, fmt.Sprintf("Undefined property '%s'.", expr.Name.Lexeme))
+	return nil
+}
+
+// VisitIndexExpr evaluates a subscript expression
+func (i *Interpreter) VisitIndexExpr(expr *Index) interface{} {
+	object := i.Evaluate(expr.Object)
+	if i.hadRuntimeError {
+		return nil
+	}
+	i.frames.push(object)
+	index := i.Evaluate(expr.Index)
+	i.frames.pop()
+	if i.hadRuntimeError {
+		return nil
+	}
+
+	switch value := object.(type) {
+	case *LoxList:
+		position, err := i.integerArg("[]", []interface{}{index}, 0)
+		if err != nil {
+			i.runtimeError(expr.Bracket, err.Error())
+			return nil
+		}
+		element, err := value.Get(position)
+		if err != nil {
+			i.runtimeError(expr.Bracket, err.Error())
+			return nil
+		}
+		return element
+	case *LoxMap:
+		key, err := mapKey("[]", index)
+		if err != nil {
+			i.runtimeError(expr.Bracket, err.Error())
+			return nil
+		}
+		element, _ := value.Get(key)
+		return element
+	case string:
+		position, err := i.integerArg("[]", []interface{}{index}, 0)
+		runes := []rune(value)
+		if err == nil && (position < 0 || position >= len(runes)) {
+			err = fmt.Errorf("String index %d out of range.", position)
+		}
+		if err != nil {
+			i.runtimeError(expr.Bracket, err.Error())
+			return nil
+		}
+		return string(runes[position])
+	case *LoxInstance:
+		if result, ok := i.callOperatorMethod(expr.Bracket, value, "__index", index); ok {
+			return result
+		}
+	}
+
+	i.runtimeError(expr.Bracket, "Only lists, maps, strings and instances with '__index' can be indexed.")
 	return nil
 }
 
@@ -362,11 +1017,30 @@ func (i *Interpreter) VisitSetExpr(expr *Set) interface{} {
 
 	// Check if the object is an instance
 	if instance, ok := object.(*LoxInstance); ok {
+		i.frames.push(instance)
 		value := i.Evaluate(expr.Value)
+		i.frames.pop()
+		if i.hadRuntimeError {
+			return nil
+		}
+
+		if isPrivateName(expr.Name) {
+			owner := i.privateOwner(expr, expr.Name, instance.class)
+			if owner == nil {
+				return nil
+			}
+			if setter, ok := owner.setters[expr.Name.Lexeme]; ok {
+				setter.Bind(instance).Call(i, []interface{}{value})
+				return value
+			}
+			instance.setPrivateField(owner, expr.Name.Lexeme, value)
+			return value
+		}
+
+		i.setProperty(instance, expr.Name, value)
 		if i.hadRuntimeError {
 			return nil
 		}
-		instance.Set(expr.Name, value)
 		return value
 	}
 
@@ -374,6 +1048,26 @@ func (i *Interpreter) VisitSetExpr(expr *Set) interface{} {
 	return nil
 }
 
+// setProperty assigns a public property on an instance, going through a setter
+// if the class defines one
+func (i *Interpreter) setProperty(instance *LoxInstance, name Token, value interface{}) {
+	// A setter takes over the assignment entirely
+	if setter := instance.class.FindSetter(name.Lexeme); setter != nil {
+		setter.Bind(instance).Call(i, []interface{}{value})
+		return
+	}
+
+	// Classes that declare their fields don't grow new ones on assignment
+	if instance.class.HasDeclaredFields() && !instance.class.DeclaresField(name.Lexeme) {
+		if _, ok := instance.field(name.Lexeme); !ok {
+			i.runtimeError(name, fmt.Sprintf("Undefined field '%s' on %s.", name.Lexeme, instance.class.name))
+			return
+		}
+	}
+
+	instance.Set(name, value)
+}
+
 // VisitLiteralExpr evaluates a literal expression
 func (i *Interpreter) VisitLiteralExpr(expr *Literal) interface{} {
 	return expr.Value
@@ -406,10 +1100,50 @@ func (i *Interpreter) VisitUnaryExpr(expr *Unary) interface{} {
 	return nil
 }
 
+// operatorMethods maps binary operators to the methods that overload them
+var operatorMethods = map[TokenType]string{
+	PLUS:          "__add",
+	MINUS:         "__sub",
+	STAR:          "__mul",
+	SLASH:         "__div",
+	EQUAL_EQUAL:   "__eq",
+	BANG_EQUAL:    "__eq",
+	LESS:          "__lt",
+	LESS_EQUAL:    "__le",
+	GREATER:       "__gt",
+	GREATER_EQUAL: "__ge",
+}
+
+// callOperatorMethod invokes an operator method on an instance with one argument.
+// It reports false if the class doesn't define the method.
+func (i *Interpreter) callOperatorMethod(token Token, instance *LoxInstance, name string, argument interface{}) (interface{}, bool) {
+	method := instance.class.FindMethod(name)
+	if method == nil {
+		return nil, false
+	}
+	if method.Arity() != 1 {
+		i.runtimeError(token, fmt.Sprintf("Operator method '%s' must take exactly 1 argument.", name))
+		return nil, true
+	}
+	return method.Bind(instance).Call(i, []interface{}{argument}), true
+}
+
 // VisitBinaryExpr evaluates a binary expression
 func (i *Interpreter) VisitBinaryExpr(expr *Binary) interface{} {
 	left := i.Evaluate(expr.Left)
+	i.frames.push(left)
 	right := i.Evaluate(expr.Right)
+	i.frames.pop()
+
+	// Instances can overload operators with special methods
+	if instance, ok := left.(*LoxInstance); ok {
+		if result, ok := i.callOperatorMethod(expr.Operator, instance, operatorMethods[expr.Operator.Type], right); ok {
+			if expr.Operator.Type == BANG_EQUAL {
+				return !i.isTruthy(result)
+			}
+			return result
+		}
+	}
 
 	switch expr.Operator.Type {
 	case PLUS:
@@ -424,13 +1158,11 @@ func (i *Interpreter) VisitBinaryExpr(expr *Binary) interface{} {
 			return leftNum + rightNum
 		}
 
-		// Check if both are non-numeric strings
+		// Both are strings - string concatenation
 		leftStr, leftIsString := left.(string)
 		rightStr, rightIsString := right.(string)
-
-		// Both are strings and neither is a number - string concatenation
-		if leftIsString && rightIsString && !leftIsNum && !rightIsNum {
-			return leftStr + rightStr
+		if leftIsString && rightIsString {
+			return i.concat.concat(leftStr, rightStr)
 		}
 
 		// If we get here, operands are not compatible (mixed types)
@@ -478,6 +1210,14 @@ func (i *Interpreter) VisitBinaryExpr(expr *Binary) interface{} {
 			return leftNum <= rightNum
 		}
 		return nil
+	case INSTANCEOF:
+		class, ok := right.(*LoxClass)
+		if !ok {
+			i.runtimeError(expr.Operator, "Right operand of 'instanceof' must be a class.")
+			return nil
+		}
+		instance, ok := left.(*LoxInstance)
+		return ok && instance.class.IsSubclassOf(class)
 	case EQUAL_EQUAL:
 		// Equality
 		return i.isEqual(left, right)
@@ -500,53 +1240,9 @@ func (i *Interpreter) isEqual(left, right interface{}) bool {
 		return false
 	}
 
-	// Try to determine if values are numbers (either string or float64)
-	leftStr, leftIsString := left.(string)
-	rightStr, rightIsString := right.(string)
-	leftNum, leftIsNum := left.(float64)
-	rightNum, rightIsNum := right.(float64)
-
-	// Check if left is a numeric string (from NUMBER token, has decimal point)
-	leftIsNumericString := false
-	if leftIsString && strings.Contains(leftStr, ".") {
-		var err error
-		leftNum, err = strconv.ParseFloat(leftStr, 64)
-		if err == nil {
-			leftIsNumericString = true
-			leftIsNum = true
-		}
-	}
-
-	// Check if right is a numeric string (from NUMBER token, has decimal point)
-	rightIsNumericString := false
-	if rightIsString && strings.Contains(rightStr, ".") {
-		var err error
-		rightNum, err = strconv.ParseFloat(rightStr, 64)
-		if err == nil {
-			rightIsNumericString = true
-			rightIsNum = true
-		}
-	}
-
-	// If both are numbers (either float64 or numeric strings), compare as numbers
-	if leftIsNum && rightIsNum {
-		return leftNum == rightNum
-	}
-
-	// If both are non-numeric strings, compare as strings
-	if leftIsString && rightIsString && !leftIsNumericString && !rightIsNumericString {
-		return leftStr == rightStr
-	}
-
-	// Check if both are booleans
-	leftBool, leftIsBool := left.(bool)
-	rightBool, rightIsBool := right.(bool)
-	if leftIsBool && rightIsBool {
-		return leftBool == rightBool
-	}
-
-	// Different types are not equal
-	return false
+	// Values of different dynamic types (numbers, strings, booleans) never compare
+	// equal; everything else compares by identity
+	return left == right
 }
 
 // isTruthy determines the truthiness of a value
@@ -563,39 +1259,24 @@ func (i *Interpreter) isTruthy(value interface{}) bool {
 
 // toNumber converts a value to a float64
 func (i *Interpreter) toNumber(value interface{}) float64 {
-	// If it's already a float64, return it
 	if num, ok := value.(float64); ok {
 		return num
 	}
 
-	// If it's a string (from scanner), parse it
-	if str, ok := value.(string); ok {
-		if num, err := strconv.ParseFloat(str, 64); err == nil {
-			return num
-		}
-	}
-
 	// Default to 0 if can't convert
 	return 0
 }
 
-// isNumber checks if a value is a number (float64 or numeric string)
+// isNumber checks if a value is a number
 func (i *Interpreter) isNumber(value interface{}) bool {
-	// Check if it's a float64
-	if _, ok := value.(float64); ok {
-		return true
-	}
-
-	// Check if it's a numeric string (has decimal point)
-	if str, ok := value.(string); ok {
-		if strings.Contains(str, ".") {
-			if _, err := strconv.ParseFloat(str, 64); err == nil {
-				return true
-			}
-		}
-	}
+	_, ok := value.(float64)
+	return ok
+}
 
-	return false
+// isString checks if a value is a string
+func (i *Interpreter) isString(value interface{}) bool {
+	_, ok := value.(string)
+	return ok
 }
 
 // checkNumberOperands validates that both operands are numbers and returns them
@@ -616,9 +1297,28 @@ func (i *Interpreter) HasRuntimeError() bool {
 // runtimeError reports a runtime error
 func (i *Interpreter) runtimeError(token Token, message string) {
 	i.hadRuntimeError = true
+
+	// Once exit() has been called, errors from stopping other tasks don't matter
+	if i.exit.requested.Load() {
+		return
+	}
+
+	// Errors in a spawned task or async function are handed to whoever waits on it
+	if i.errorSink != nil {
+		i.errorSink.fail(message, token.Line)
+		return
+	}
+
 	fmt.Fprintf(os.Stderr, "%s\n[line %d]\n", message, token.Line)
 }
 
+// internalError reports a Go panic that escaped while interpreting. It points at a
+// bug in the interpreter rather than in the script, but is reported like a runtime
+// error so the script still stops with exit code 70.
+func (i *Interpreter) internalError(r interface{}) {
+	i.runtimeError(i.callToken, fmt.Sprintf("Internal error: %v", r))
+}
+
 // Stringify converts a value to its string representation for output
 func (i *Interpreter) Stringify(value interface{}) string {
 	if value == nil {
@@ -635,25 +1335,110 @@ func (i *Interpreter) Stringify(value interface{}) string {
 		return fn.String()
 	}
 
+	// For the clock native, use its String() method
+	if clock, ok := value.(*ClockNative); ok {
+		return clock.String()
+	}
+
+	// For native functions, use their String() method
+	if native, ok := value.(*NativeFunction); ok {
+		return native.String()
+	}
+
 	// For LoxClass, use its String() method
 	if class, ok := value.(*LoxClass); ok {
 		return class.String()
 	}
 
-	// For LoxInstance, use its String() method
+	// For LoxTrait, use its String() method
+	if trait, ok := value.(*LoxTrait); ok {
+		return trait.String()
+	}
+
+	// For LoxInstance, prefer a toString or __str method over the default. The
+	// method must return a string; anything else, such as the instance itself,
+	// could send stringifying around in circles.
 	if instance, ok := value.(*LoxInstance); ok {
+		for _, name := range []string{"toString", "__str"} {
+			if method := instance.class.FindMethod(name); method != nil && method.Arity() == 0 {
+				result := method.Bind(instance).Call(i, nil)
+				if i.hadRuntimeError {
+					return ""
+				}
+				text, ok := result.(string)
+				if !ok {
+					i.runtimeError(method.declaration.Name, fmt.Sprintf("'%s' must return a string.", name))
+					return ""
+				}
+				return text
+			}
+		}
 		return instance.String()
 	}
 
-	// For strings that represent numbers (from scanner), parse and format them
-	if str, ok := value.(string); ok {
-		// Try to parse as float to see if it's a number
-		if num, err := strconv.ParseFloat(str, 64); err == nil {
-			// It's a number - format with minimum decimal places
-			formatted := strconv.FormatFloat(num, 'f', -1, 64)
-			return formatted
+	// For lists, stringify each element. A list that contains itself prints
+	// as [...] where it comes up again.
+	if list, ok := value.(*LoxList); ok {
+		if !i.startPrinting(list) {
+			return "[...]"
+		}
+		defer delete(i.printing, list)
+		snapshot := list.snapshot()
+		elements := make([]string, len(snapshot))
+		for index, element := range snapshot {
+			elements[index] = i.Stringify(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	}
+
+	// For generators, use their String() method
+	if generator, ok := value.(*LoxGenerator); ok {
+		return generator.String()
+	}
+
+	// For tasks and channels, use their String() method
+	if task, ok := value.(*LoxTask); ok {
+		return task.String()
+	}
+	if channel, ok := value.(*LoxChannel); ok {
+		return channel.String()
+	}
+
+	if promise, ok := value.(*LoxPromise); ok {
+		return promise.String()
+	}
+
+	// String builders print their contents
+	if builder, ok := value.(*LoxStringBuilder); ok {
+		return builder.String()
+	}
+
+	if ref, ok := value.(*LoxWeakRef); ok {
+		return ref.String()
+	}
+
+	// For ranges, use their String() method
+	if r, ok := value.(*LoxRange); ok {
+		return r.String()
+	}
+
+	// For maps, stringify each entry in insertion order, printing a map that
+	// contains itself as {...}
+	if m, ok := value.(*LoxMap); ok {
+		if !i.startPrinting(m) {
+			return "{...}"
 		}
-		// Not a number, return string as-is (for string literals)
+		defer delete(i.printing, m)
+		keys, values := m.entries()
+		entries := make([]string, len(keys))
+		for index, key := range keys {
+			entries[index] = i.Stringify(key) + ": " + i.Stringify(values[index])
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	}
+
+	// Strings are printed as-is
+	if str, ok := value.(string); ok {
 		return str
 	}
 
@@ -666,3 +1451,16 @@ func (i *Interpreter) Stringify(value interface{}) string {
 	// Default formatting
 	return fmt.Sprintf("%v", value)
 }
+
+// startPrinting marks a list or map as being stringified, returning false if it
+// already is
+func (i *Interpreter) startPrinting(container interface{}) bool {
+	if i.printing[container] {
+		return false
+	}
+	if i.printing == nil {
+		i.printing = make(map[interface{}]bool)
+	}
+	i.printing[container] = true
+	return true
+}
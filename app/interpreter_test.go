@@ -0,0 +1,186 @@
+package main
+
+import "testing"
+
+func TestStringifyMarksCycles(t *testing.T) {
+	source := `
+var l = list(1);
+l.push(l);
+print l;
+var m = map();
+m.set("self", m);
+m.set("list", l);
+print m;
+var shared = list(2);
+print list(shared, shared);
+`
+	want := "[1, [...]]\n{self: {...}, list: [1, [...]]}\n[[2], [2]]\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestTraitConflictIsReportedInNameOrder(t *testing.T) {
+	source := `
+trait A { walk() {} talk() {} swim() {} }
+trait B { walk() {} talk() {} swim() {} }
+class C with A, B {}
+`
+	want := "Method 'swim' is provided by both traits A and B; override it in C.\n[line 4]\n"
+	// Map iteration order varies from run to run, so try several times
+	for attempt := 0; attempt < 20; attempt++ {
+		if _, errors := runFailingScript(t, source, Options{}); errors != want {
+			t.Fatalf("got error %q, want %q", errors, want)
+		}
+	}
+}
+
+func TestTraitsMixInMethods(t *testing.T) {
+	source := `
+trait Greets { greet() { return "hi " + this.name(); } name() { return "trait"; } }
+trait Walks { walk() { return "walking"; } }
+class P with Greets, Walks { name() { return "p"; } }
+print P().greet();
+print P().walk();
+class Base { walk() { return "base"; } }
+class Q < Base with Greets {}
+print Q().walk();
+print Q().greet();
+class R with Greets, Walks { greet() { return "own"; } }
+print R().greet();
+print Greets;
+`
+	// The class's own methods win over a trait's, which win over inherited ones
+	want := "hi p\nwalking\nbase\nhi trait\nown\n<trait Greets>\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestTraitErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"var T = 1;\nclass A with T {}", "Can only mix in traits.\n[line 2]\n"},
+		{"class A {}\nclass B with A {}", "Can only mix in traits.\n[line 2]\n"},
+		{"class A with Nope {}", "Undefined variable 'Nope'.\n[line 1]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+
+	errors := compileErrors(t, "trait T { m() { return super.m(); } }")
+	if want := "[line 1] Error at 'super': Can't use 'super' in a class with no superclass.\n"; errors != want {
+		t.Errorf("got error %q, want %q", errors, want)
+	}
+}
+
+func TestOperatorMethods(t *testing.T) {
+	source := `
+class Vec {
+  init(x, y) { this.x = x; this.y = y; }
+  __add(o) { return Vec(this.x + o.x, this.y + o.y); }
+  __mul(k) { return Vec(this.x * k, this.y * k); }
+  __eq(o) { return this.x == o.x and this.y == o.y; }
+  __lt(o) { return this.x < o.x; }
+  __index(i) { if (i == 0) return this.x; return this.y; }
+  toString() { return "Vec(" + str(this.x) + ", " + str(this.y) + ")"; }
+}
+var a = Vec(1, 2) + Vec(3, 4);
+print a;
+print a * 2;
+print a == Vec(4, 6);
+print a != Vec(4, 6);
+print Vec(1, 0) < Vec(2, 0);
+print a[1];
+print "v: " + str(a);
+class Named { __str() { return "named"; } }
+print Named();
+class Plain {}
+print Plain() == Plain();
+class One { __eq(o) { return o == 1; } }
+print 1 == One();
+`
+	// Only the left operand's methods are consulted, and classes without __eq
+	// compare by identity
+	want := "Vec(4, 6)\nVec(8, 12)\ntrue\nfalse\ntrue\n6\nv: Vec(4, 6)\nnamed\nfalse\nfalse\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestOperatorMethodErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"class A { __add() { return 1; } }\nprint A() + 1;", "Operator method '__add' must take exactly 1 argument.\n[line 2]\n"},
+		{"class A { toString() { return this; } }\nprint A();", "'toString' must return a string.\n[line 1]\n"},
+		{"class A {}\nprint A()[0];", "Only lists, maps, strings and instances with '__index' can be indexed.\n[line 2]\n"},
+		{"class A { __lt(o) { return true; } }\nprint A() > 1;", "Operands must be numbers.\n[line 2]\n"},
+		{"class A { __sub(o) { return 1; } }\nprint 1 - A();", "Operands must be numbers.\n[line 2]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
+
+func TestTailCallsRunInConstantStack(t *testing.T) {
+	source := `
+fun isEven(n) { if (n == 0) return true; return isOdd(n - 1); }
+fun isOdd(n) { if (n == 0) return false; return isEven(n - 1); }
+print isEven(1000000);
+print isOdd(1000001);
+fun count(n, acc) {
+  if (n == 0) return acc;
+  { return count(n - 1, acc + 1); }
+}
+print count(1000000, 0);
+fun down(n) { if (n > 0) return down(n - 1); else return "done"; }
+print down(1000000);
+class Machine {
+  init() { this.steps = 0; }
+  run(n) {
+    if (n == 0) return this.steps;
+    this.steps = this.steps + 1;
+    return this.run(n - 1);
+  }
+  restart() { return this.init(); }
+}
+var m = Machine();
+print m.run(500000);
+print m.restart().steps;
+class P { init(x) { this.x = x; } }
+fun make(x) { return P(x); }
+print make(3).x;
+fun viaNative() { return str(42); }
+print viaNative();
+`
+	// Mutual recursion a million calls deep would overflow the stack without
+	// tail calls; calls to classes and natives in tail position still return
+	want := "true\ntrue\n1000000\ndone\n500000\n0\n3\n42\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestTailCallErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"fun bad() { return nil(); }\nbad();", "Can only call functions and classes.\n[line 1]\n"},
+		{"fun g(a) {}\nfun f() { return g(); }\nf();", "Expected 1 arguments but got 0.\n[line 2]\n"},
+		{"fun f(n) {\n  if (n == 0) return 1 / nil;\n  return f(n - 1);\n}\nf(100000);", "Operands must be numbers.\n[line 2]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
 )
 
 func main() {
@@ -24,6 +26,24 @@ func main() {
 	// Uncomment this block to pass the first stage
 
 	filename := os.Args[2]
+
+	// parse --program parses a whole file as statements rather than one expression
+	program := false
+	if command == "parse" && filename == "--program" {
+		if len(os.Args) < 4 {
+			fmt.Fprintln(os.Stderr, "Usage: ./your_program.sh parse --program <filename>")
+			os.Exit(1)
+		}
+		program = true
+		filename = os.Args[3]
+	}
+
+	options := Options{}
+	heapDumpFile := ""
+	if command == "run" {
+		options, heapDumpFile, filename = parseRunFlags(os.Args[2:])
+	}
+
 	fileContents, err := os.ReadFile(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
@@ -31,6 +51,9 @@ func main() {
 	}
 
 	scanner := NewScanner(string(fileContents))
+	if command != "tokenize" {
+		scanner.AllowExtensions()
+	}
 	tokens := scanner.ScanTokens()
 
 	if command == "tokenize" {
@@ -41,6 +64,21 @@ func main() {
 		if scanner.HasError() {
 			os.Exit(65)
 		}
+	} else if command == "parse" && program {
+		if scanner.HasError() {
+			os.Exit(65)
+		}
+
+		parser := NewParser(tokens)
+		statements := parser.ParseStatements()
+
+		if parser.HasError() {
+			os.Exit(65)
+		}
+
+		if len(statements) > 0 {
+			fmt.Println(NewAstPrinter().PrintProgram(statements))
+		}
 	} else if command == "parse" {
 		if scanner.HasError() {
 			os.Exit(65)
@@ -93,7 +131,7 @@ func main() {
 			os.Exit(65)
 		}
 
-		interpreter := NewInterpreter()
+		interpreter := NewInterpreterWithOptions(options)
 
 		// Resolve variable bindings
 		resolver := NewResolver(interpreter)
@@ -103,10 +141,71 @@ func main() {
 			os.Exit(65)
 		}
 
+		// Fold constants and drop dead branches
+		statements = NewOptimizer(interpreter).Optimize(statements)
+
 		interpreter.InterpretStatements(statements)
 
+		// Run timers and async continuations left over by the script
+		if !interpreter.HasRuntimeError() {
+			interpreter.RunEventLoop()
+		}
+
+		// The dump is written even after a runtime error, when it's most useful
+		if heapDumpFile != "" {
+			if err := writeHeapDump(interpreter, heapDumpFile); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		if code, ok := interpreter.ExitCode(); ok {
+			os.Exit(code)
+		}
 		if interpreter.HasRuntimeError() {
 			os.Exit(70)
 		}
 	}
 }
+
+// parseRunFlags parses the flags accepted by the run command, returning the options,
+// the file to write a heap dump to, if any, and the script's filename. Flags must
+// come before the filename; anything after it is passed to the script via args().
+func parseRunFlags(arguments []string) (Options, string, string) {
+	options := Options{}
+	heapDumpFile := ""
+
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.BoolVar(&options.AllowFS, "allow-fs", false, "allow the script to read and write files")
+	flags.BoolVar(&options.AllowEnv, "allow-env", false, "allow the script to read environment variables")
+	flags.Func("seed", "seed the random natives so runs are reproducible", func(value string) error {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		options.Seed = &seed
+		return nil
+	})
+	flags.StringVar(&heapDumpFile, "heap-dump", "", "write a JSON heap dump to this file when the script finishes")
+	flags.Parse(arguments)
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: ./your_program.sh run [flags] <filename> [args...]")
+		os.Exit(1)
+	}
+
+	options.Args = flags.Args()[1:]
+	return options, heapDumpFile, flags.Arg(0)
+}
+
+// writeHeapDump writes the interpreter's heap dump to a file
+func writeHeapDump(interpreter *Interpreter, filename string) error {
+	dump, err := interpreter.heapDumpJSON()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, []byte(dump+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error writing heap dump: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defineJSONNatives registers jsonParse and jsonStringify in the given environment
+func defineJSONNatives(env *Environment) {
+	env.Define("jsonParse", NewNativeFunction("jsonParse", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		source, err := interpreter.stringArg("jsonParse", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		return parseJSON(source)
+	}))
+
+	env.Define("jsonStringify", NewNativeFunction("jsonStringify", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		indent, err := jsonIndent(arguments[1])
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := &jsonEncoder{interpreter: interpreter, seen: make(map[interface{}]bool)}
+		if err := encoder.encode(arguments[0]); err != nil {
+			return nil, err
+		}
+		if indent == "" {
+			return encoder.buffer.String(), nil
+		}
+
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, encoder.buffer.Bytes(), "", indent); err != nil {
+			return nil, err
+		}
+		return indented.String(), nil
+	}))
+}
+
+// jsonIndent converts the indent argument of jsonStringify: nil for compact output,
+// a number of spaces, or a literal indent string
+func jsonIndent(value interface{}) (string, error) {
+	switch indent := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return indent, nil
+	case float64:
+		if indent < 0 || indent != math.Trunc(indent) {
+			return "", fmt.Errorf("JSON indent must be a non-negative whole number.")
+		}
+		return strings.Repeat(" ", int(indent)), nil
+	}
+	return "", fmt.Errorf("JSON indent must be nil, a number or a string.")
+}
+
+// parseJSON decodes a JSON document into Lox values: objects become maps (keeping key
+// order), arrays become lists and null becomes nil
+func parseJSON(source string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(source))
+	value, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, jsonError(source, decoder.InputOffset(), err)
+	}
+
+	// Only whitespace may follow the top-level value. Reading the next token moves
+	// past it, so note where it starts first.
+	offset := decoder.InputOffset()
+	for offset < int64(len(source)) && strings.IndexByte(" \t\r\n", source[offset]) >= 0 {
+		offset++
+	}
+	if _, extra := decoder.Token(); extra != io.EOF {
+		return nil, jsonError(source, offset, fmt.Errorf("unexpected data after top-level value"))
+	}
+	return value, nil
+}
+
+// decodeJSONValue reads one complete value from the decoder's token stream
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '[' {
+			elements := []interface{}{}
+			for decoder.More() {
+				element, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, element)
+			}
+			_, err := decoder.Token()
+			return NewLoxList(elements), err
+		}
+
+		object := NewLoxMap()
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			element, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object.Set(key.(string), element)
+		}
+		_, err := decoder.Token()
+		return object, err
+	case float64, string, bool, nil:
+		return value, nil
+	}
+
+	return nil, fmt.Errorf("unexpected token %v", token)
+}
+
+// jsonError converts a decoding error into a message with a line and column
+func jsonError(source string, offset int64, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+		// The offset points just past the offending character unless input ran out
+		if offset > 0 && !strings.HasPrefix(syntaxErr.Error(), "unexpected end") {
+			offset--
+		}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		offset = int64(len(source))
+		err = fmt.Errorf("unexpected end of input")
+	}
+
+	line, column := 1, 1
+	for _, c := range source[:offset] {
+		if c == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Errorf("Invalid JSON at line %d, column %d: %v.", line, column, err)
+}
+
+// jsonEncoder writes Lox values as compact JSON, tracking the containers on the
+// current path so cycles are reported instead of recursing forever
+type jsonEncoder struct {
+	interpreter *Interpreter
+	buffer      bytes.Buffer
+	seen        map[interface{}]bool
+}
+
+func (e *jsonEncoder) encode(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		e.buffer.WriteString("null")
+	case bool:
+		e.buffer.WriteString(strconv.FormatBool(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("Cannot convert %s to JSON.", e.interpreter.Stringify(v))
+		}
+		e.buffer.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case string:
+		e.encodeString(v)
+	case *LoxList:
+		return e.encodeContainer(v, func() error {
+			e.buffer.WriteByte('[')
+			for index, element := range v.snapshot() {
+				if index > 0 {
+					e.buffer.WriteByte(',')
+				}
+				if err := e.encode(element); err != nil {
+					return err
+				}
+			}
+			e.buffer.WriteByte(']')
+			return nil
+		})
+	case *LoxMap:
+		return e.encodeContainer(v, func() error {
+			keys, values := v.entries()
+			return e.encodeObject(len(keys), func(index int) (interface{}, interface{}) {
+				return keys[index], values[index]
+			})
+		})
+	case *LoxInstance:
+		return e.encodeContainer(v, func() error {
+			// Fields have no declaration order, so sort them for stable output
+			fields := v.fieldSnapshot()
+			names := make([]string, 0, len(fields))
+			for name := range fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return e.encodeObject(len(names), func(index int) (interface{}, interface{}) {
+				return names[index], fields[names[index]]
+			})
+		})
+	default:
+		return fmt.Errorf("Cannot convert %s to JSON.", e.interpreter.Stringify(v))
+	}
+	return nil
+}
+
+// encodeContainer marks a list, map or instance as in progress while it is written
+func (e *jsonEncoder) encodeContainer(container interface{}, write func() error) error {
+	if e.seen[container] {
+		return fmt.Errorf("Cannot convert cyclic structure to JSON.")
+	}
+	e.seen[container] = true
+	defer delete(e.seen, container)
+	return write()
+}
+
+// encodeObject writes count key/value pairs as a JSON object
+func (e *jsonEncoder) encodeObject(count int, entry func(index int) (interface{}, interface{})) error {
+	e.buffer.WriteByte('{')
+	for index := 0; index < count; index++ {
+		key, value := entry(index)
+		name, ok := key.(string)
+		if !ok {
+			return fmt.Errorf("Cannot convert map with key %s to JSON; keys must be strings.", e.interpreter.Stringify(key))
+		}
+		if index > 0 {
+			e.buffer.WriteByte(',')
+		}
+		e.encodeString(name)
+		e.buffer.WriteByte(':')
+		if err := e.encode(value); err != nil {
+			return err
+		}
+	}
+	e.buffer.WriteByte('}')
+	return nil
+}
+
+// encodeString writes a quoted JSON string without HTML escaping
+func (e *jsonEncoder) encodeString(str string) {
+	encoder := json.NewEncoder(&e.buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(str)
+	// Encode always appends a newline
+	e.buffer.Truncate(e.buffer.Len() - 1)
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestParseJSONErrorPositions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`"x" 5`, "Invalid JSON at line 1, column 5: unexpected data after top-level value."},
+		{"[1]\n  x", "Invalid JSON at line 2, column 3: unexpected data after top-level value."},
+		{`1 }`, "Invalid JSON at line 1, column 3: unexpected data after top-level value."},
+		{`[1, 2`, "Invalid JSON at line 1, column 6: unexpected end of JSON input."},
+		{`{"a" 1}`, "Invalid JSON at line 1, column 6: invalid character '1' after object key."},
+	}
+	for _, test := range tests {
+		_, err := parseJSON(test.source)
+		if err == nil {
+			t.Errorf("parseJSON(%q) succeeded, want an error", test.source)
+		} else if err.Error() != test.want {
+			t.Errorf("parseJSON(%q) error = %q, want %q", test.source, err.Error(), test.want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	output := runScript(t, `
+		var m = map();
+		m.set("z", 1);
+		m.set("a", list(1.5, "x<y", nil, true));
+		var inner = map();
+		inner.set("k", "v");
+		m.set("inner", inner);
+		var s = jsonStringify(m, nil);
+		print s;
+		print jsonStringify(jsonParse(s), nil) == s;
+		print jsonStringify(list(1, list()), 2);
+		class P { init() { this.y = 2; this.x = 1; } }
+		print jsonStringify(P(), nil);
+	`, Options{})
+
+	want := `{"z":1,"a":[1.5,"x<y",null,true],"inner":{"k":"v"}}` + "\ntrue\n[\n  1,\n  []\n]\n" + `{"x":1,"y":2}` + "\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestJSONStringifyErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`jsonStringify(nan, nil);`, "Cannot convert NaN to JSON.\n[line 1]\n"},
+		{`var l = list(); l.push(l); jsonStringify(l, nil);`, "Cannot convert cyclic structure to JSON.\n[line 1]\n"},
+		{`var m = map(); m.set(1, 2); jsonStringify(m, nil);`, "Cannot convert map with key 1 to JSON; keys must be strings.\n[line 1]\n"},
+		{`jsonStringify(clock, nil);`, "Cannot convert <native fn> to JSON.\n[line 1]\n"},
+		{`jsonStringify(1, -1);`, "JSON indent must be a non-negative whole number.\n[line 1]\n"},
+		{`jsonStringify(1, true);`, "JSON indent must be nil, a number or a string.\n[line 1]\n"},
+		{`jsonParse(1);`, "Argument 1 to 'jsonParse' must be a string.\n[line 1]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%s: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
+
+func TestParseJSONValues(t *testing.T) {
+	interpreter := NewInterpreter()
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{` {"b": [1, {"c": null}], "a": "\u00e9"} `, "{b: [1, {c: nil}], a: é}"},
+		{`[]`, "[]"},
+		{`-0.5e1`, "-5"},
+		{`false`, "false"},
+	}
+	for _, test := range tests {
+		value, err := parseJSON(test.source)
+		if err != nil {
+			t.Errorf("parseJSON(%q) failed: %v", test.source, err)
+		} else if got := interpreter.Stringify(value); got != test.want {
+			t.Errorf("parseJSON(%q) = %s, want %s", test.source, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+)
+
+// defineMathNatives registers the math module in the given environment
+func defineMathNatives(env *Environment) {
+	unary := map[string]func(float64) float64{
+		"sqrt":  math.Sqrt,
+		"floor": math.Floor,
+		"ceil":  math.Ceil,
+		"round": math.Round,
+		"abs":   math.Abs,
+		"sin":   math.Sin,
+		"cos":   math.Cos,
+		"tan":   math.Tan,
+		"log":   math.Log,
+		"exp":   math.Exp,
+	}
+	for name, fn := range unary {
+		env.Define(name, mathUnary(name, fn))
+	}
+
+	binary := map[string]func(float64, float64) float64{
+		"pow":   math.Pow,
+		"min":   math.Min,
+		"max":   math.Max,
+		"atan2": math.Atan2,
+	}
+	for name, fn := range binary {
+		env.Define(name, mathBinary(name, fn))
+	}
+
+	env.Define("isNan", NewNativeFunction("isNan", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		x, err := interpreter.numberArg("isNan", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		return math.IsNaN(x), nil
+	}))
+
+	// Constants are plain values rather than functions
+	env.Define("pi", math.Pi)
+	env.Define("inf", math.Inf(1))
+	env.Define("nan", math.NaN())
+}
+
+// mathUnary wraps a one-argument math function as a native
+func mathUnary(name string, fn func(float64) float64) *NativeFunction {
+	return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		x, err := interpreter.numberArg(name, arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		return fn(x), nil
+	})
+}
+
+// mathBinary wraps a two-argument math function as a native
+func mathBinary(name string, fn func(float64, float64) float64) *NativeFunction {
+	return NewNativeFunction(name, 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		x, err := interpreter.numberArg(name, arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		y, err := interpreter.numberArg(name, arguments, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fn(x, y), nil
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestMathNatives(t *testing.T) {
+	output := runScript(t, `
+		print sqrt(16);
+		print pow(2, 10);
+		print floor(-1.5);
+		print ceil(-1.5);
+		print round(2.5);
+		print round(-2.5);
+		print abs(-3);
+		print atan2(1, 1) * 4 == pi;
+		print exp(0);
+	`, Options{})
+
+	want := "4\n1024\n-2\n-1\n3\n-3\n3\ntrue\n1\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestMathEdgeValues(t *testing.T) {
+	output := runScript(t, `
+		print sqrt(-1);
+		print isNan(sqrt(-1));
+		print nan == nan;
+		print min(1, nan);
+		print max(inf, 1);
+		print -inf;
+		print log(0);
+		print 1 / 0;
+	`, Options{})
+
+	want := "NaN\ntrue\nfalse\nNaN\n+Inf\n-Inf\n-Inf\n+Inf\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestMathArgumentErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`sqrt("4");`, "Argument 1 to 'sqrt' must be a number.\n[line 1]\n"},
+		{`min(1, true);`, "Argument 2 to 'min' must be a number.\n[line 1]\n"},
+		{`isNan(nil);`, "Argument 1 to 'isNan' must be a number.\n[line 1]\n"},
+		{`pow(1);`, "Expected 2 arguments but got 1.\n[line 1]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%s: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// lockedSource makes a random source safe to share between concurrent tasks
+type lockedSource struct {
+	mu     sync.Mutex
+	source rand.Source64
+}
+
+func newLockedSource(seed int64) *lockedSource {
+	return &lockedSource{source: rand.NewSource(seed).(rand.Source64)}
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.Seed(seed)
+}
+
+// defineRandomNatives registers the random module in the given environment. All
+// natives share the interpreter's generator, so a fixed seed reproduces a whole run.
+func defineRandomNatives(env *Environment) {
+	env.Define("random", NewNativeFunction("random", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return interpreter.random.Float64(), nil
+	}))
+
+	env.Define("randomInt", NewNativeFunction("randomInt", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		lo, err := interpreter.integerArg("randomInt", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := interpreter.integerArg("randomInt", arguments, 1)
+		if err != nil {
+			return nil, err
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("randomInt lower bound %d is greater than upper bound %d.", lo, hi)
+		}
+		if hi-lo >= maxExactInteger {
+			return nil, fmt.Errorf("randomInt bounds %d and %d are too far apart; the range can span at most 2^53 values.", lo, hi)
+		}
+		// Both bounds are inclusive
+		return float64(lo + interpreter.random.Intn(hi-lo+1)), nil
+	}))
+
+	env.Define("choice", NewNativeFunction("choice", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		list, err := interpreter.listArg("choice", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		list.mu.RLock()
+		defer list.mu.RUnlock()
+		if len(list.elements) == 0 {
+			return nil, fmt.Errorf("Can't choose from an empty list.")
+		}
+		return list.elements[interpreter.random.Intn(len(list.elements))], nil
+	}))
+
+	env.Define("shuffle", NewNativeFunction("shuffle", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		list, err := interpreter.listArg("shuffle", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		list.mu.Lock()
+		defer list.mu.Unlock()
+		interpreter.random.Shuffle(len(list.elements), func(a, b int) {
+			list.elements[a], list.elements[b] = list.elements[b], list.elements[a]
+		})
+		return list, nil
+	}))
+
+	env.Define("seed", NewNativeFunction("seed", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		seed, err := interpreter.integerArg("seed", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		interpreter.random.Seed(int64(seed))
+		return nil, nil
+	}))
+}
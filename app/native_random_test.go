@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestSeededRunMatchesGolden checks that a seeded run reproduces the output
+// recorded in testdata, so scripts using the random natives can be golden tested
+func TestSeededRunMatchesGolden(t *testing.T) {
+	source, err := os.ReadFile("testdata/random.lox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile("testdata/random.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed := int64(42)
+	for run := 0; run < 2; run++ {
+		output := runScript(t, string(source), Options{Seed: &seed})
+		if output != string(golden) {
+			t.Fatalf("run %d got output:\n%s\nwant:\n%s", run+1, output, golden)
+		}
+	}
+}
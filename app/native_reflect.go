@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// defineReflectionNatives registers the natives for inspecting values at runtime
+func defineReflectionNatives(env *Environment) {
+	env.Define("type", NewNativeFunction("type", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return typeName(arguments[0]), nil
+	}))
+
+	env.Define("classOf", NewNativeFunction("classOf", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		if instance, ok := arguments[0].(*LoxInstance); ok {
+			return instance.class, nil
+		}
+		return nil, nil
+	}))
+
+	env.Define("fields", NewNativeFunction("fields", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		instance, err := instanceArg("fields", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		// Private fields are kept apart, so they're never listed
+		names := []string{}
+		for name := range instance.fieldSnapshot() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return stringList(names), nil
+	}))
+
+	env.Define("methods", NewNativeFunction("methods", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		class, ok := arguments[0].(*LoxClass)
+		if instance, isInstance := arguments[0].(*LoxInstance); isInstance {
+			class, ok = instance.class, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("Argument 1 to 'methods' must be a class or an instance.")
+		}
+
+		// Include inherited methods, listing each name once
+		seen := make(map[string]bool)
+		names := []string{}
+		for current := class; current != nil; current = current.superclass {
+			for name := range current.methods {
+				if !seen[name] && name[0] != '#' {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+		sort.Strings(names)
+		return stringList(names), nil
+	}))
+
+	env.Define("hasField", NewNativeFunction("hasField", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		instance, name, err := interpreter.fieldArgs("hasField", arguments)
+		if err != nil {
+			return nil, err
+		}
+		_, ok := instance.field(name.Lexeme)
+		return ok, nil
+	}))
+
+	env.Define("getField", NewNativeFunction("getField", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		instance, name, err := interpreter.fieldArgs("getField", arguments)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := instance.Get(interpreter, name)
+		if !ok {
+			return nil, fmt.Errorf("Undefined property '%s'.", name.Lexeme)
+		}
+		return value, nil
+	}))
+
+	env.Define("setField", NewNativeFunction("setField", 3, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		instance, name, err := interpreter.fieldArgs("setField", arguments)
+		if err != nil {
+			return nil, err
+		}
+		interpreter.setProperty(instance, name, arguments[2])
+		return arguments[2], nil
+	}))
+}
+
+// typeName returns the name type() reports for a value
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *LoxFunction, *NativeFunction, *ClockNative:
+		return "function"
+	case *LoxClass:
+		return "class"
+	case *LoxTrait:
+		return "trait"
+	case *LoxInstance:
+		return "instance"
+	case *LoxList:
+		return "list"
+	case *LoxMap:
+		return "map"
+	case *LoxRange:
+		return "range"
+	case *LoxGenerator:
+		return "generator"
+	case *LoxTask:
+		return "task"
+	case *LoxChannel:
+		return "channel"
+	case *LoxPromise:
+		return "promise"
+	case *LoxStringBuilder:
+		return "stringbuilder"
+	case *LoxWeakRef:
+		return "weakref"
+	}
+	return "unknown"
+}
+
+// instanceArg returns the argument at index as an instance, or an error naming the native
+func instanceArg(name string, arguments []interface{}, index int) (*LoxInstance, error) {
+	instance, ok := arguments[index].(*LoxInstance)
+	if !ok {
+		return nil, fmt.Errorf("Argument %d to '%s' must be an instance.", index+1, name)
+	}
+	return instance, nil
+}
+
+// fieldArgs returns the instance and property name passed to a field native. The
+// name is wrapped in a token on the call's line so property errors point at the call.
+func (i *Interpreter) fieldArgs(native string, arguments []interface{}) (*LoxInstance, Token, error) {
+	instance, err := instanceArg(native, arguments, 0)
+	if err != nil {
+		return nil, Token{}, err
+	}
+	name, err := i.stringArg(native, arguments, 1)
+	if err != nil {
+		return nil, Token{}, err
+	}
+	if name == "" || name[0] == '#' {
+		return nil, Token{}, fmt.Errorf("Can't access private member '%s' from here.", name)
+	}
+	return instance, Token{Type: IDENTIFIER, Lexeme: name, Literal: "null", Line: i.callToken.Line}, nil
+}
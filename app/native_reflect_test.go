@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestReflectionNatives(t *testing.T) {
+	source := `
+class A { init() { this.a = 1; } m() {} }
+class B < A { n() {} area { return 2; } }
+var b = B();
+print type(1); print type("s"); print type(nil); print type(true);
+print type(b); print type(B); print type(clock); print type(list()); print type(map());
+print b instanceof A; print b instanceof B; print A() instanceof B; print 1 instanceof A;
+print classOf(b); print classOf(3);
+setField(b, "z", 5);
+print fields(b); print methods(B); print methods(b);
+print hasField(b, "z"); print hasField(b, "q");
+print getField(b, "area"); print getField(b, "a");
+var name = "dyn"; setField(b, name, "v"); print getField(b, name);
+`
+	want := "number\nstring\nnil\nbool\ninstance\nclass\nfunction\nlist\nmap\n" +
+		"true\ntrue\nfalse\nfalse\nB\nnil\n[a, z]\n[init, m, n]\n[init, m, n]\n" +
+		"true\nfalse\n2\n1\nv\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestReflectionErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"methods(1);", "Argument 1 to 'methods' must be a class or an instance.\n[line 1]\n"},
+		{"class A {}\ngetField(A(), \"q\");", "Undefined property 'q'.\n[line 2]\n"},
+		{"getField(1, \"a\");", "Argument 1 to 'getField' must be an instance.\n[line 1]\n"},
+		{"class A {}\nsetField(A(), 1, 2);", "Argument 2 to 'setField' must be a string.\n[line 2]\n"},
+		{"class A { var #x = 1; }\ngetField(A(), \"#x\");", "Can't access private member '#x' from here.\n[line 2]\n"},
+		{"class A {}\nprint A() instanceof 3;", "Right operand of 'instanceof' must be a class.\n[line 2]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// defineRegexNatives registers the regular expression natives in the given
+// environment. Patterns use Go's RE2 syntax.
+func defineRegexNatives(env *Environment) {
+	env.Define("regexMatch", NewNativeFunction("regexMatch", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		re, str, err := interpreter.regexArgs("regexMatch", arguments)
+		if err != nil {
+			return nil, err
+		}
+		match := re.FindStringSubmatchIndex(str)
+		if match == nil {
+			return nil, nil
+		}
+		return regexGroups(re, str, match), nil
+	}))
+
+	env.Define("regexFindAll", NewNativeFunction("regexFindAll", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		re, str, err := interpreter.regexArgs("regexFindAll", arguments)
+		if err != nil {
+			return nil, err
+		}
+		matches := []interface{}{}
+		for _, match := range re.FindAllStringSubmatchIndex(str, -1) {
+			if re.NumSubexp() == 0 {
+				matches = append(matches, str[match[0]:match[1]])
+			} else {
+				matches = append(matches, regexGroups(re, str, match))
+			}
+		}
+		return NewLoxList(matches), nil
+	}))
+
+	env.Define("regexReplace", NewNativeFunction("regexReplace", 3, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		re, str, err := interpreter.regexArgs("regexReplace", arguments)
+		if err != nil {
+			return nil, err
+		}
+		replacement, err := interpreter.stringArg("regexReplace", arguments, 2)
+		if err != nil {
+			return nil, err
+		}
+		// The replacement may refer to groups as $1 or ${name}
+		return re.ReplaceAllString(str, replacement), nil
+	}))
+
+	env.Define("regexSplit", NewNativeFunction("regexSplit", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		re, str, err := interpreter.regexArgs("regexSplit", arguments)
+		if err != nil {
+			return nil, err
+		}
+		return stringList(re.Split(str, -1)), nil
+	}))
+}
+
+// regexArgs compiles the pattern argument and returns it with the subject string
+func (i *Interpreter) regexArgs(name string, arguments []interface{}) (*regexp.Regexp, string, error) {
+	pattern, err := i.stringArg(name, arguments, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	str, err := i.stringArg(name, arguments, 1)
+	if err != nil {
+		return nil, "", err
+	}
+	re, err := i.compileRegex(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	return re, str, nil
+}
+
+// compileRegex compiles a pattern, reusing earlier compilations of the same pattern
+func (i *Interpreter) compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := i.regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		var syntaxErr *syntax.Error
+		if errors.As(err, &syntaxErr) {
+			// Go's parser reports the failing fragment rather than an offset, so find
+			// where the fragment starts and report it as a 1-based character position
+			if index := strings.Index(pattern, syntaxErr.Expr); index >= 0 {
+				position := utf8.RuneCountInString(pattern[:index]) + 1
+				return nil, fmt.Errorf("Invalid regex pattern '%s': %s at position %d in '%s'.", pattern, syntaxErr.Code, position, syntaxErr.Expr)
+			}
+			return nil, fmt.Errorf("Invalid regex pattern '%s': %s in '%s'.", pattern, syntaxErr.Code, syntaxErr.Expr)
+		}
+		return nil, fmt.Errorf("Invalid regex pattern '%s': %v.", pattern, err)
+	}
+
+	i.regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// regexGroups converts a submatch index slice into Lox values. Patterns with named
+// groups produce a map from group name to text; otherwise a list holds the whole
+// match followed by each group. Groups that did not participate are nil.
+func regexGroups(re *regexp.Regexp, str string, match []int) interface{} {
+	group := func(index int) interface{} {
+		if match[2*index] < 0 {
+			return nil
+		}
+		return str[match[2*index]:match[2*index+1]]
+	}
+
+	names := re.SubexpNames()
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		groups := NewLoxMap()
+		for index, name := range names {
+			if name != "" {
+				groups.Set(name, group(index))
+			}
+		}
+		return groups
+	}
+
+	groups := make([]interface{}, len(names))
+	for index := range names {
+		groups[index] = group(index)
+	}
+	return NewLoxList(groups)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegexErrorReportsPosition(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`ab[c`, "Invalid regex pattern 'ab[c': missing closing ] at position 3 in '[c'."},
+		{`x*+`, "Invalid regex pattern 'x*+': invalid nested repetition operator at position 2 in '*+'."},
+		{`é\q`, "Invalid regex pattern 'é\\q': invalid escape sequence at position 2 in '\\q'."},
+		{`a(b`, "Invalid regex pattern 'a(b': missing closing ) at position 1 in 'a(b'."},
+	}
+	for _, test := range tests {
+		_, errors := runFailingScript(t, `regexMatch("`+test.pattern+`", "abc");`, Options{})
+		if !strings.HasPrefix(errors, test.want) {
+			t.Errorf("pattern %q: got error %q, want %q", test.pattern, errors, test.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defineStringNatives registers the type conversion natives in the given environment
+func defineStringNatives(env *Environment) {
+	env.Define("str", NewNativeFunction("str", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return interpreter.Stringify(arguments[0]), nil
+	}))
+
+	env.Define("num", NewNativeFunction("num", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		switch value := arguments[0].(type) {
+		case float64:
+			return value, nil
+		case string:
+			num, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot convert '%s' to a number.", value)
+			}
+			return num, nil
+		}
+		return nil, fmt.Errorf("Cannot convert %s to a number.", interpreter.Stringify(arguments[0]))
+	}))
+}
+
+// stringMethod returns the built-in method with the given name bound to the string, or nil.
+// Positions and lengths are measured in characters rather than bytes.
+func stringMethod(str string, name string) *NativeFunction {
+	switch name {
+	case "len":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return float64(len([]rune(str))), nil
+		})
+	case "upper":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return strings.ToUpper(str), nil
+		})
+	case "lower":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return strings.ToLower(str), nil
+		})
+	case "trim":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return strings.TrimSpace(str), nil
+		})
+	case "split":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			separator, err := interpreter.stringArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			return stringList(strings.Split(str, separator)), nil
+		})
+	case "contains":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			substr, err := interpreter.stringArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			return strings.Contains(str, substr), nil
+		})
+	case "startsWith":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			prefix, err := interpreter.stringArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			return strings.HasPrefix(str, prefix), nil
+		})
+	case "endsWith":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			suffix, err := interpreter.stringArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			return strings.HasSuffix(str, suffix), nil
+		})
+	case "indexOf":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			substr, err := interpreter.stringArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			index := strings.Index(str, substr)
+			if index < 0 {
+				return float64(-1), nil
+			}
+			return float64(len([]rune(str[:index]))), nil
+		})
+	case "replace":
+		return NewNativeFunction(name, 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			old, err := interpreter.stringArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			replacement, err := interpreter.stringArg(name, arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			return strings.ReplaceAll(str, old, replacement), nil
+		})
+	case "substring":
+		return NewNativeFunction(name, 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			start, err := interpreter.integerArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			end, err := interpreter.integerArg(name, arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			runes := []rune(str)
+			if start < 0 || end > len(runes) || start > end {
+				return nil, fmt.Errorf("Substring range %d..%d out of bounds for length %d.", start, end, len(runes))
+			}
+			return string(runes[start:end]), nil
+		})
+	case "repeat":
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			count, err := interpreter.integerArg(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			if count < 0 {
+				return nil, fmt.Errorf("Repeat count can't be negative.")
+			}
+			return strings.Repeat(str, count), nil
+		})
+	case "chars":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			chars := []string{}
+			for _, r := range str {
+				chars = append(chars, string(r))
+			}
+			return stringList(chars), nil
+		})
+	}
+	return nil
+}
+
+// stringList converts a slice of Go strings into a Lox list
+func stringList(strs []string) *LoxList {
+	elements := make([]interface{}, len(strs))
+	for index, str := range strs {
+		elements[index] = str
+	}
+	return NewLoxList(elements)
+}
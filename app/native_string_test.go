@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestStringMethodsCountCharacters(t *testing.T) {
+	output := runScript(t, `
+		print "héllo".len();
+		print "héllo".upper();
+		print "héllo".indexOf("l");
+		print "abc".indexOf("z");
+		print "héllo".substring(1, 3);
+		print "abc".substring(3, 3) == "";
+		print "héllo".chars();
+	`, Options{})
+
+	want := "5\nHÉLLO\n2\n-1\nél\ntrue\n[h, é, l, l, o]\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestStringMethodEdgeCases(t *testing.T) {
+	output := runScript(t, `
+		print "  x ".trim() + "|";
+		print "a,b,,c".split(",");
+		print "".split(",").len();
+		print "abc".split("");
+		print "aaa".replace("a", "bb");
+		print "ab".repeat(3);
+		print "ab".repeat(0) == "";
+		print "abc".startsWith("") and "abc".endsWith("bc");
+		print num(" 42 ");
+		print str(nil) + str(true);
+	`, Options{})
+
+	want := "x|\n[a, b, , c]\n1\n[a, b, c]\nbbbbbb\nababab\ntrue\ntrue\n42\nniltrue\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestStringMethodErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`"abc".substring(2, 1);`, "Substring range 2..1 out of bounds for length 3.\n[line 1]\n"},
+		{`"abc".substring(0, 4);`, "Substring range 0..4 out of bounds for length 3.\n[line 1]\n"},
+		{`"abc".repeat(-1);`, "Repeat count can't be negative.\n[line 1]\n"},
+		{`"abc".repeat(1.5);`, "Argument 1 to 'repeat' must be a whole number.\n[line 1]\n"},
+		{`"abc".split(1);`, "Argument 1 to 'split' must be a string.\n[line 1]\n"},
+		{`num("4x");`, "Cannot convert '4x' to a number.\n[line 1]\n"},
+		{`num(nil);`, "Cannot convert nil to a number.\n[line 1]\n"},
+		{`"abc".foo();`, "Undefined property 'foo'.\n[line 1]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%s: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// defineSystemNatives registers the file system and process natives in the given
+// environment. File system and environment access are checked on every call so the
+// natives fail with a clear message when the capability has not been granted.
+//
+// A file system native whose I/O fails, say because a file doesn't exist, returns
+// nil rather than stopping the script, and ioError() returns what went wrong. The
+// natives that return nothing else return true when they succeed.
+func defineSystemNatives(env *Environment) {
+	env.Define("readFile", NewNativeFunction("readFile", 1, recoverIO(func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		path, err := interpreter.fsPathArg("readFile", arguments)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, ioError("read", path, err)
+		}
+		return string(contents), nil
+	})))
+
+	env.Define("writeFile", NewNativeFunction("writeFile", 2, recoverIO(func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return true, interpreter.writeFile("writeFile", arguments, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	})))
+
+	env.Define("appendFile", NewNativeFunction("appendFile", 2, recoverIO(func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return true, interpreter.writeFile("appendFile", arguments, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	})))
+
+	env.Define("listDir", NewNativeFunction("listDir", 1, recoverIO(func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		path, err := interpreter.fsPathArg("listDir", arguments)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, ioError("list", path, err)
+		}
+		names := make([]string, len(entries))
+		for index, entry := range entries {
+			names[index] = entry.Name()
+		}
+		sort.Strings(names)
+		return stringList(names), nil
+	})))
+
+	env.Define("exists", NewNativeFunction("exists", 1, recoverIO(func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		path, err := interpreter.fsPathArg("exists", arguments)
+		if err != nil {
+			return nil, err
+		}
+		_, err = os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		} else if err != nil {
+			return nil, ioError("stat", path, err)
+		}
+		return true, nil
+	})))
+
+	env.Define("remove", NewNativeFunction("remove", 1, recoverIO(func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		path, err := interpreter.fsPathArg("remove", arguments)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, ioError("remove", path, err)
+		}
+		return true, nil
+	})))
+
+	env.Define("ioError", NewNativeFunction("ioError", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return interpreter.lastIOError, nil
+	}))
+
+	env.Define("getEnv", NewNativeFunction("getEnv", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		if !interpreter.options.AllowEnv {
+			return nil, fmt.Errorf("'getEnv' requires environment access; run with --allow-env.")
+		}
+		name, err := interpreter.stringArg("getEnv", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	}))
+
+	env.Define("args", NewNativeFunction("args", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return stringList(interpreter.options.Args), nil
+	}))
+
+	env.Define("exit", NewNativeFunction("exit", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		code, err := interpreter.integerArg("exit", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &exitRequest{code: code}
+	}))
+}
+
+// recoverIO wraps a file system native so that an I/O failure makes it return nil
+// and is kept for ioError(), while a misuse, such as a missing capability or an
+// argument of the wrong type, still stops the script
+func recoverIO(fn func(*Interpreter, []interface{}) (interface{}, error)) func(*Interpreter, []interface{}) (interface{}, error) {
+	return func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		result, err := fn(interpreter, arguments)
+		var failure *ioFailure
+		if errors.As(err, &failure) {
+			interpreter.lastIOError = failure.Error()
+			return nil, nil
+		}
+		if err == nil {
+			interpreter.lastIOError = nil
+		}
+		return result, err
+	}
+}
+
+// ioFailure is an I/O operation that failed, as opposed to a native being misused
+type ioFailure struct {
+	message string
+}
+
+func (e *ioFailure) Error() string {
+	return e.message
+}
+
+// exitRequest is returned by exit(). Rather than exiting on the spot, the script
+// unwinds as if from a runtime error, without reporting one, and main exits with
+// the code once it has written the heap dump.
+type exitRequest struct {
+	code int
+}
+
+func (e *exitRequest) Error() string {
+	return fmt.Sprintf("exit(%d)", e.code)
+}
+
+// exitStatus records a call to exit(). It's shared by the interpreters running a
+// script's tasks and async functions, each of which stops at its next statement
+// once any of them has called exit().
+type exitStatus struct {
+	requested atomic.Bool
+	code      atomic.Int64
+}
+
+// request records the exit code, keeping the first if exit() is called again
+func (s *exitStatus) request(code int) {
+	if s.requested.CompareAndSwap(false, true) {
+		s.code.Store(int64(code))
+	}
+}
+
+// ExitCode returns the code the script passed to exit(), and whether it called it
+func (i *Interpreter) ExitCode() (int, bool) {
+	if !i.exit.requested.Load() {
+		return 0, false
+	}
+	return int(i.exit.code.Load()), true
+}
+
+// fsPathArg checks that file system access is allowed and returns the path argument
+func (i *Interpreter) fsPathArg(name string, arguments []interface{}) (string, error) {
+	if !i.options.AllowFS {
+		return "", fmt.Errorf("'%s' requires file system access; run with --allow-fs.", name)
+	}
+	return i.stringArg(name, arguments, 0)
+}
+
+// writeFile implements writeFile and appendFile, which differ only in their open flags
+func (i *Interpreter) writeFile(name string, arguments []interface{}, flag int) error {
+	path, err := i.fsPathArg(name, arguments)
+	if err != nil {
+		return err
+	}
+	contents, err := i.stringArg(name, arguments, 1)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return ioError("open", path, err)
+	}
+	if _, err := file.WriteString(contents); err != nil {
+		file.Close()
+		return ioError("write", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return ioError("write", path, err)
+	}
+	return nil
+}
+
+// ioError formats an I/O failure without repeating the path Go already includes
+func ioError(action string, path string, err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &ioFailure{message: fmt.Sprintf("Could not %s '%s': %v.", action, path, err)}
+}
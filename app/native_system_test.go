@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExitUnwindsWithCode(t *testing.T) {
+	interpreter, printed, errors := interpretScript(t, `
+		print 1;
+		exit(3);
+		print 2;
+	`, Options{})
+
+	if printed != "1\n" || errors != "" {
+		t.Errorf("got output %q and errors %q, want %q and none", printed, errors, "1\n")
+	}
+	if code, ok := interpreter.ExitCode(); !ok || code != 3 {
+		t.Errorf("ExitCode() = %d, %v, want 3, true", code, ok)
+	}
+}
+
+func TestExitFromTaskAndTimer(t *testing.T) {
+	interpreter, printed, errors := interpretScript(t, `
+		fun work() { exit(4); }
+		var task = spawn work();
+		task.join();
+		print "unreachable";
+	`, Options{})
+	if code, _ := interpreter.ExitCode(); code != 4 || printed != "" || errors != "" {
+		t.Errorf("task: got code %d, output %q, errors %q", code, printed, errors)
+	}
+
+	interpreter, printed, errors = interpretScript(t, `
+		fun first() { print "first"; exit(2); }
+		fun second() { print "second"; }
+		setTimeout(first, 1);
+		setTimeout(second, 20);
+	`, Options{})
+	if code, _ := interpreter.ExitCode(); code != 2 || printed != "first\n" || errors != "" {
+		t.Errorf("timer: got code %d, output %q, errors %q", code, printed, errors)
+	}
+}
+
+func TestFileErrorsReturnNil(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.txt")
+	written := filepath.Join(dir, "written.txt")
+
+	output := runScript(t, `
+		print readFile("`+missing+`");
+		print ioError();
+		print writeFile("`+written+`", "data");
+		print ioError();
+		print readFile("`+written+`");
+		print remove("`+written+`");
+		print remove("`+written+`");
+		print ioError() != nil;
+		print listDir("`+missing+`");
+	`, Options{AllowFS: true})
+
+	want := "nil\nCould not read '" + missing + "': no such file or directory.\n" +
+		"true\nnil\ndata\ntrue\nnil\ntrue\nnil\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+	if _, err := os.Stat(written); !os.IsNotExist(err) {
+		t.Errorf("%s wasn't removed", written)
+	}
+}
+
+func TestFileMisuseIsStillAnError(t *testing.T) {
+	_, errors := runFailingScript(t, `readFile("x");`, Options{})
+	if !strings.HasPrefix(errors, "'readFile' requires file system access; run with --allow-fs.") {
+		t.Errorf("got errors %q", errors)
+	}
+
+	_, errors = runFailingScript(t, `readFile(1);`, Options{AllowFS: true})
+	if !strings.Contains(errors, "readFile") {
+		t.Errorf("got errors %q", errors)
+	}
+}
+
+func TestFileNativesRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.txt")
+
+	output := runScript(t, `
+		print exists("`+path+`");
+		appendFile("`+path+`", "a");
+		appendFile("`+path+`", "b");
+		print readFile("`+path+`");
+		writeFile("`+path+`", "c");
+		print readFile("`+path+`");
+		writeFile("`+filepath.Join(dir, "another.txt")+`", "");
+		print listDir("`+dir+`");
+	`, Options{AllowFS: true})
+
+	want := "false\nab\nc\n[another.txt, log.txt]\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+}
+
+func TestProcessNatives(t *testing.T) {
+	t.Setenv("LOX_TEST_VAR", "set")
+	output := runScript(t, `
+		print args();
+		print getEnv("LOX_TEST_VAR");
+		print getEnv("LOX_TEST_MISSING");
+	`, Options{AllowEnv: true, Args: []string{"a", "b"}})
+
+	want := "[a, b]\nset\nnil\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`getEnv("LOX_TEST_VAR");`, "'getEnv' requires environment access; run with --allow-env.\n[line 1]\n"},
+		{`writeFile("x", "y");`, "'writeFile' requires file system access; run with --allow-fs.\n[line 1]\n"},
+		{`exit(1.5);`, "Argument 1 to 'exit' must be a whole number.\n[line 1]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%s: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}
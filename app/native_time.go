@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock is the time source used by the time natives. Embedders can supply a
+// FakeClock through Options to make scripts that read the time deterministic.
+type Clock interface {
+	Now() time.Time
+	Sleep(duration time.Duration)
+}
+
+// systemClock reads the real wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(duration time.Duration) {
+	time.Sleep(duration)
+}
+
+// FakeClock is a manually controlled clock; sleeping advances it instantly
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(duration time.Duration) {
+	c.Advance(duration)
+}
+
+// Advance moves the clock forward by duration
+func (c *FakeClock) Advance(duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(duration)
+}
+
+// unixSeconds converts a time to fractional seconds since the Unix epoch
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// fromUnixSeconds converts fractional seconds since the Unix epoch to a UTC time
+func fromUnixSeconds(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+}
+
+// defineTimeNatives registers the time module in the given environment. Times are
+// passed around as fractional seconds since the Unix epoch, and layouts use Go's
+// reference time ("2006-01-02 15:04:05").
+func defineTimeNatives(env *Environment) {
+	env.Define("now", NewNativeFunction("now", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		return unixSeconds(interpreter.options.Clock.Now()), nil
+	}))
+
+	env.Define("monotonic", NewNativeFunction("monotonic", 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		// Seconds since the interpreter started; unaffected by wall clock changes
+		elapsed := interpreter.options.Clock.Now().Sub(interpreter.startTime)
+		return elapsed.Seconds(), nil
+	}))
+
+	env.Define("sleep", NewNativeFunction("sleep", 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		ms, err := interpreter.numberArg("sleep", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		if ms < 0 {
+			return nil, fmt.Errorf("Sleep duration can't be negative.")
+		}
+		interpreter.options.Clock.Sleep(time.Duration(ms * float64(time.Millisecond)))
+		return nil, nil
+	}))
+
+	env.Define("formatTime", NewNativeFunction("formatTime", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		seconds, err := interpreter.numberArg("formatTime", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		layout, err := interpreter.stringArg("formatTime", arguments, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fromUnixSeconds(seconds).Format(layout), nil
+	}))
+
+	env.Define("parseTime", NewNativeFunction("parseTime", 2, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		value, err := interpreter.stringArg("parseTime", arguments, 0)
+		if err != nil {
+			return nil, err
+		}
+		layout, err := interpreter.stringArg("parseTime", arguments, 1)
+		if err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse time '%s' with layout '%s'.", value, layout)
+		}
+		return unixSeconds(t), nil
+	}))
+}
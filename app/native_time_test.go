@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeNativesUseInjectedClock(t *testing.T) {
+	start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	output := runScript(t, `
+		print now();
+		print monotonic();
+		sleep(1500);
+		print now();
+		print monotonic();
+		print formatTime(now(), "2006-01-02 15:04:05");
+	`, Options{Clock: clock})
+
+	want := "1709294400\n0\n1709294401.5\n1.5\n2024-03-01 12:00:01\n"
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+
+	// Sleeping advanced the injected clock rather than waiting
+	if elapsed := clock.Now().Sub(start); elapsed != 1500*time.Millisecond {
+		t.Errorf("clock advanced by %v, want 1.5s", elapsed)
+	}
+}
+
+func TestFakeClockAdvance(t *testing.T) {
+	clock := NewFakeClock(time.Unix(100, 0))
+	interpreter := NewInterpreterWithOptions(Options{Clock: clock})
+
+	clock.Advance(2 * time.Second)
+	elapsed := clock.Now().Sub(interpreter.startTime)
+	if elapsed != 2*time.Second {
+		t.Errorf("elapsed = %v, want 2s", elapsed)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+// Optimizer rewrites a resolved program before it runs. It folds operators whose
+// operands are all literals into a single literal and drops branches that can never
+// run. Each visitor method returns the node to use in place of the one visited;
+// statement visitors return nil for a statement that can be removed.
+//
+// Folding evaluates the operator with the interpreter itself, so the folded value
+// is exactly what the program would have computed. An operator that would fail at
+// runtime, such as -"a", is left in place so the error is still reported when and
+// where it happens.
+type Optimizer struct {
+	interpreter *Interpreter
+}
+
+func NewOptimizer(interpreter *Interpreter) *Optimizer {
+	return &Optimizer{interpreter: interpreter}
+}
+
+// discardErrors is the error sink for trial evaluations while folding
+type discardErrors struct{}
+
+func (discardErrors) fail(message string, line int) {}
+
+// Optimize returns the optimized form of a list of statements
+func (o *Optimizer) Optimize(statements []Stmt) []Stmt {
+	optimized := make([]Stmt, 0, len(statements))
+	for _, stmt := range statements {
+		if stmt = o.optimizeStmt(stmt); stmt != nil {
+			optimized = append(optimized, stmt)
+		}
+	}
+	return optimized
+}
+
+// optimizeStmt optimizes a statement, returning nil if it can be removed
+func (o *Optimizer) optimizeStmt(stmt Stmt) Stmt {
+	result, _ := stmt.Accept(o).(Stmt)
+	return result
+}
+
+// optimizeBranch optimizes a statement that must stay a statement, such as the
+// body of a loop, replacing a removed one with an empty block
+func (o *Optimizer) optimizeBranch(stmt Stmt) Stmt {
+	if stmt = o.optimizeStmt(stmt); stmt == nil {
+		return &Block{Statements: []Stmt{}}
+	}
+	return stmt
+}
+
+// optimizeExpr optimizes an expression
+func (o *Optimizer) optimizeExpr(expr Expr) Expr {
+	return expr.Accept(o).(Expr)
+}
+
+// fold evaluates an expression whose operands are all literals, returning the
+// literal holding its value, or the expression itself if evaluating it fails
+func (o *Optimizer) fold(expr Expr) Expr {
+	trial := o.interpreter.fork(o.interpreter.globals)
+	trial.errorSink = discardErrors{}
+
+	value := trial.Evaluate(expr)
+	if trial.hadRuntimeError {
+		return expr
+	}
+	return &Literal{Value: value}
+}
+
+// literalValue returns the value of an expression if it is a literal
+func literalValue(expr Expr) (interface{}, bool) {
+	if literal, ok := expr.(*Literal); ok {
+		return literal.Value, true
+	}
+	return nil, false
+}
+
+// Statement visitor methods
+
+// VisitPrintStmt optimizes a print statement
+func (o *Optimizer) VisitPrintStmt(stmt *Print) interface{} {
+	stmt.Expression = o.optimizeExpr(stmt.Expression)
+	return stmt
+}
+
+// VisitExpressionStmt optimizes an expression statement
+func (o *Optimizer) VisitExpressionStmt(stmt *Expression) interface{} {
+	stmt.Expression = o.optimizeExpr(stmt.Expression)
+	return stmt
+}
+
+// VisitVarStmt optimizes a variable declaration
+func (o *Optimizer) VisitVarStmt(stmt *Var) interface{} {
+	if stmt.Initializer != nil {
+		stmt.Initializer = o.optimizeExpr(stmt.Initializer)
+	}
+	return stmt
+}
+
+// VisitBlockStmt optimizes the statements of a block
+func (o *Optimizer) VisitBlockStmt(stmt *Block) interface{} {
+	stmt.Statements = o.Optimize(stmt.Statements)
+	return stmt
+}
+
+// VisitIfStmt optimizes an if statement. With a literal condition only the branch
+// that would run is kept. Branches can't be declarations, so removing one never
+// changes the variables of the enclosing scope.
+func (o *Optimizer) VisitIfStmt(stmt *If) interface{} {
+	stmt.Condition = o.optimizeExpr(stmt.Condition)
+
+	if condition, ok := literalValue(stmt.Condition); ok {
+		if o.interpreter.isTruthy(condition) {
+			return o.optimizeStmt(stmt.ThenBranch)
+		}
+		if stmt.ElseBranch != nil {
+			return o.optimizeStmt(stmt.ElseBranch)
+		}
+		return nil
+	}
+
+	stmt.ThenBranch = o.optimizeBranch(stmt.ThenBranch)
+	if stmt.ElseBranch != nil {
+		stmt.ElseBranch = o.optimizeStmt(stmt.ElseBranch)
+	}
+	return stmt
+}
+
+// VisitWhileStmt optimizes a while loop, removing it if its condition is a literal
+// that is never true
+func (o *Optimizer) VisitWhileStmt(stmt *While) interface{} {
+	stmt.Condition = o.optimizeExpr(stmt.Condition)
+	if condition, ok := literalValue(stmt.Condition); ok && !o.interpreter.isTruthy(condition) {
+		return nil
+	}
+
+	stmt.Body = o.optimizeBranch(stmt.Body)
+	if stmt.Increment != nil {
+		stmt.Increment = o.optimizeExpr(stmt.Increment)
+	}
+	return stmt
+}
+
+// VisitForInStmt optimizes a for-in loop
+func (o *Optimizer) VisitForInStmt(stmt *ForIn) interface{} {
+	stmt.Iterable = o.optimizeExpr(stmt.Iterable)
+	stmt.Body = o.optimizeBranch(stmt.Body)
+	return stmt
+}
+
+// VisitFunctionStmt optimizes a function's body
+func (o *Optimizer) VisitFunctionStmt(stmt *Function) interface{} {
+	o.optimizeFunction(stmt)
+	return stmt
+}
+
+// optimizeFunction optimizes the body of a function or method
+func (o *Optimizer) optimizeFunction(function *Function) {
+	function.Body = o.Optimize(function.Body)
+}
+
+// VisitReturnStmt optimizes a return statement
+func (o *Optimizer) VisitReturnStmt(stmt *Return) interface{} {
+	if stmt.Value != nil {
+		stmt.Value = o.optimizeExpr(stmt.Value)
+	}
+	return stmt
+}
+
+// VisitBreakStmt leaves a break statement as it is
+func (o *Optimizer) VisitBreakStmt(stmt *Break) interface{} {
+	return stmt
+}
+
+// VisitContinueStmt leaves a continue statement as it is
+func (o *Optimizer) VisitContinueStmt(stmt *Continue) interface{} {
+	return stmt
+}
+
+// VisitYieldStmt optimizes a yield statement
+func (o *Optimizer) VisitYieldStmt(stmt *Yield) interface{} {
+	if stmt.Value != nil {
+		stmt.Value = o.optimizeExpr(stmt.Value)
+	}
+	return stmt
+}
+
+// VisitClassStmt optimizes field initializers and method bodies
+func (o *Optimizer) VisitClassStmt(stmt *Class) interface{} {
+	for _, field := range stmt.Fields {
+		o.VisitVarStmt(field)
+	}
+	for _, methods := range [][]*Function{stmt.Methods, stmt.ClassMethods, stmt.Getters, stmt.Setters} {
+		for _, method := range methods {
+			o.optimizeFunction(method)
+		}
+	}
+	return stmt
+}
+
+// VisitTraitStmt optimizes a trait's method bodies
+func (o *Optimizer) VisitTraitStmt(stmt *Trait) interface{} {
+	for _, method := range stmt.Methods {
+		o.optimizeFunction(method)
+	}
+	return stmt
+}
+
+// Expression visitor methods
+
+// VisitLiteralExpr leaves a literal as it is
+func (o *Optimizer) VisitLiteralExpr(expr *Literal) interface{} {
+	return expr
+}
+
+// VisitGroupingExpr replaces a parenthesized literal with the literal
+func (o *Optimizer) VisitGroupingExpr(expr *Grouping) interface{} {
+	expr.Expression = o.optimizeExpr(expr.Expression)
+	if literal, ok := expr.Expression.(*Literal); ok {
+		return literal
+	}
+	return expr
+}
+
+// VisitUnaryExpr folds a unary operator applied to a literal
+func (o *Optimizer) VisitUnaryExpr(expr *Unary) interface{} {
+	expr.Right = o.optimizeExpr(expr.Right)
+	if _, ok := literalValue(expr.Right); ok {
+		return o.fold(expr)
+	}
+	return expr
+}
+
+// VisitBinaryExpr folds a binary operator applied to two literals
+func (o *Optimizer) VisitBinaryExpr(expr *Binary) interface{} {
+	expr.Left = o.optimizeExpr(expr.Left)
+	expr.Right = o.optimizeExpr(expr.Right)
+
+	_, leftIsLiteral := literalValue(expr.Left)
+	_, rightIsLiteral := literalValue(expr.Right)
+	if leftIsLiteral && rightIsLiteral {
+		return o.fold(expr)
+	}
+	return expr
+}
+
+// VisitLogicalExpr short-circuits a logical operator whose left operand is a
+// literal: the result is either that literal or the right operand
+func (o *Optimizer) VisitLogicalExpr(expr *Logical) interface{} {
+	expr.Left = o.optimizeExpr(expr.Left)
+	expr.Right = o.optimizeExpr(expr.Right)
+
+	left, ok := literalValue(expr.Left)
+	if !ok {
+		return expr
+	}
+	truthy := o.interpreter.isTruthy(left)
+	if (expr.Operator.Type == OR) == truthy {
+		return expr.Left
+	}
+	return expr.Right
+}
+
+// VisitVariableExpr leaves a variable as it is
+func (o *Optimizer) VisitVariableExpr(expr *Variable) interface{} {
+	return expr
+}
+
+// VisitAssignmentExpr optimizes the assigned value
+func (o *Optimizer) VisitAssignmentExpr(expr *Assignment) interface{} {
+	expr.Value = o.optimizeExpr(expr.Value)
+	return expr
+}
+
+// VisitCallExpr optimizes the callee and arguments of a call
+func (o *Optimizer) VisitCallExpr(expr *Call) interface{} {
+	o.optimizeCall(expr)
+	return expr
+}
+
+// optimizeCall optimizes a call in place
+func (o *Optimizer) optimizeCall(expr *Call) {
+	expr.Callee = o.optimizeExpr(expr.Callee)
+	for index, argument := range expr.Arguments {
+		expr.Arguments[index] = o.optimizeExpr(argument)
+	}
+}
+
+// VisitGetExpr optimizes the object of a property access
+func (o *Optimizer) VisitGetExpr(expr *Get) interface{} {
+	expr.Object = o.optimizeExpr(expr.Object)
+	return expr
+}
+
+// VisitSetExpr optimizes the object and value of a property assignment
+func (o *Optimizer) VisitSetExpr(expr *Set) interface{} {
+	expr.Object = o.optimizeExpr(expr.Object)
+	expr.Value = o.optimizeExpr(expr.Value)
+	return expr
+}
+
+// VisitThisExpr leaves this as it is
+func (o *Optimizer) VisitThisExpr(expr *This) interface{} {
+	return expr
+}
+
+// VisitSuperExpr leaves super as it is
+func (o *Optimizer) VisitSuperExpr(expr *Super) interface{} {
+	return expr
+}
+
+// VisitIndexExpr optimizes the object and index of a subscript
+func (o *Optimizer) VisitIndexExpr(expr *Index) interface{} {
+	expr.Object = o.optimizeExpr(expr.Object)
+	expr.Index = o.optimizeExpr(expr.Index)
+	return expr
+}
+
+// VisitSpawnExpr optimizes the call started by spawn
+func (o *Optimizer) VisitSpawnExpr(expr *Spawn) interface{} {
+	o.optimizeCall(expr.Call)
+	return expr
+}
+
+// VisitAwaitExpr optimizes the awaited value
+func (o *Optimizer) VisitAwaitExpr(expr *Await) interface{} {
+	expr.Value = o.optimizeExpr(expr.Value)
+	return expr
+}
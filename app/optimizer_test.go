@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+// optimized parses, resolves and optimizes a program and prints what's left of it
+func optimized(t *testing.T, source string) string {
+	t.Helper()
+	scanner := NewScanner(source)
+	scanner.AllowExtensions()
+	parser := NewParser(scanner.ScanTokens())
+	statements := parser.ParseStatements()
+	interpreter := NewInterpreter()
+	resolver := NewResolver(interpreter)
+	resolver.Resolve(statements)
+	if scanner.HasError() || parser.HasError() || resolver.HasError() {
+		t.Fatalf("script doesn't compile:\n%s", source)
+	}
+	return NewAstPrinter().PrintProgram(NewOptimizer(interpreter).Optimize(statements))
+}
+
+func TestOptimizerFoldsAndDropsBranches(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`print 1 + 2 * 3;`, "(print 7.0)"},
+		{`print "a" + "b";`, "(print ab)"},
+		{`print nil and f();`, "(print nil)"},
+		{`var x = 1; print x + 1 * 2;`, "(var x 1.0)\n(print (+ x 2.0))"},
+		{`if (1 > 2) print 1; else print 2;`, "(print 2.0)"},
+		{`while (false) print 1;`, ""},
+		{`fun f() { if (false) return 1; return 2 + 3; }`, "(fun f ()\n  (return 5.0))"},
+		// The initializer of a loop that never runs is still executed
+		{`for (var i = 0; false; i = i + 1) print i;`, "(block\n  (var i 0.0))"},
+		// Operators that would fail are left for the runtime to report
+		{`print -"a";`, "(print (- a))"},
+		{`print 1 + "a";`, "(print (+ 1.0 a))"},
+	}
+	for _, test := range tests {
+		if got := optimized(t, test.source); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestFoldedProgramsBehaveTheSame(t *testing.T) {
+	source := `
+print 1 + 2 * 3;
+print (1 + 2) * (3 - 1) / 4;
+print !nil;
+print true and "yes";
+print false or nil or "last";
+print 1 == 1.0;
+print "1" == 1;
+print 7 / 0;
+for (var i = 0; false; i = i + 1) print i;
+if (true) print "then";
+`
+	want := "7\n1.5\ntrue\nyes\nlast\ntrue\nfalse\n+Inf\nthen\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// An unfoldable operator fails on the line it was written on
+	_, errors := runFailingScript(t, "print 1;\nprint\n  -\"a\";", Options{})
+	if want := "Operand must be a number.\n[line 3]\n"; errors != want {
+		t.Errorf("got error %q, want %q", errors, want)
+	}
+}
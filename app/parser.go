@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 )
 
 // Parser implements a recursive descent parser
@@ -10,6 +11,24 @@ type Parser struct {
 	tokens   []Token
 	current  int
 	hadError bool
+
+	// The body being parsed is a generator's or an async function's, where yield
+	// or await is always a keyword rather than possibly a name
+	inGenerator bool
+	inAsync     bool
+}
+
+// parseError is a syntax error that stops the parser. It's returned up to the
+// enclosing declaration, which reports it, synchronizes and carries on. Errors
+// that don't stop the parser, such as an invalid assignment target, are reported
+// where they're found instead.
+type parseError struct {
+	token   Token
+	message string
+}
+
+func (e *parseError) Error() string {
+	return e.message
 }
 
 func NewParser(tokens []Token) *Parser {
@@ -20,16 +39,15 @@ func NewParser(tokens []Token) *Parser {
 	}
 }
 
-// Parse parses the tokens and returns an expression
+// Parse parses the tokens and returns an expression, or nil after reporting a
+// syntax error
 func (p *Parser) Parse() Expr {
-	defer func() {
-		if r := recover(); r != nil {
-			// Caught a parse error, just return nil
-			// The error has already been reported
-		}
-	}()
-
-	return p.expression()
+	expr, err := p.expression()
+	if err != nil {
+		p.report(err)
+		return nil
+	}
+	return expr
 }
 
 // ParseStatements parses a list of statements
@@ -46,23 +64,37 @@ func (p *Parser) ParseStatements() []Stmt {
 	return statements
 }
 
-// declaration parses a declaration (var statement or regular statement)
+// declaration parses a declaration (var statement or regular statement). After a
+// syntax error it reports the error, skips to the next statement and returns nil.
 func (p *Parser) declaration() Stmt {
-	defer func() {
-		if r := recover(); r != nil {
-			// Panic mode: synchronize to the next statement
-			p.synchronize()
-		}
-	}()
+	stmt, err := p.declarationOrError()
+	if err != nil {
+		p.report(err)
+		p.synchronize()
+		return nil
+	}
+	return stmt
+}
 
+// declarationOrError parses a declaration, returning the first syntax error
+func (p *Parser) declarationOrError() (Stmt, error) {
 	if p.match(CLASS) {
 		return p.classDeclaration()
 	}
 
+	if p.peekNext().Type == IDENTIFIER && p.match(TRAIT) {
+		return p.traitDeclaration()
+	}
+
 	if p.match(FUN) {
 		return p.function("function")
 	}
 
+	if p.peekNext().Type == FUN && p.match(ASYNC) {
+		p.advance() // "fun"
+		return p.asyncFunction("function")
+	}
+
 	if p.match(VAR) {
 		return p.varDeclaration()
 	}
@@ -71,64 +103,277 @@ func (p *Parser) declaration() Stmt {
 }
 
 // varDeclaration parses a variable declaration
-func (p *Parser) varDeclaration() Stmt {
-	name := p.consume(IDENTIFIER, "Expect variable name.")
+func (p *Parser) varDeclaration() (Stmt, error) {
+	name, err := p.consume(IDENTIFIER, "Expect variable name.")
+	if err != nil {
+		return nil, err
+	}
 
 	var initializer Expr
 	if p.match(EQUAL) {
-		initializer = p.expression()
+		if initializer, err = p.expression(); err != nil {
+			return nil, err
+		}
 	}
 
-	p.consume(SEMICOLON, "Expect ';' after variable declaration.")
-	return &Var{Name: name, Initializer: initializer}
+	if _, err := p.consume(SEMICOLON, "Expect ';' after variable declaration."); err != nil {
+		return nil, err
+	}
+	return &Var{Name: name, Initializer: initializer}, nil
 }
 
 // classDeclaration parses a class declaration
-func (p *Parser) classDeclaration() Stmt {
-	name := p.consume(IDENTIFIER, "Expect class name.")
+func (p *Parser) classDeclaration() (Stmt, error) {
+	name, err := p.consume(IDENTIFIER, "Expect class name.")
+	if err != nil {
+		return nil, err
+	}
 
 	// Check for superclass
 	var superclass *Variable
 	if p.match(LESS) {
-		p.consume(IDENTIFIER, "Expect superclass name.")
+		if _, err := p.consume(IDENTIFIER, "Expect superclass name."); err != nil {
+			return nil, err
+		}
 		superclass = &Variable{Name: p.previous()}
 	}
 
-	p.consume(LEFT_BRACE, "Expect '{' before class body.")
+	// Check for mixed-in traits
+	traits := []*Variable{}
+	if p.match(WITH) {
+		for {
+			if _, err := p.consume(IDENTIFIER, "Expect trait name."); err != nil {
+				return nil, err
+			}
+			traits = append(traits, &Variable{Name: p.previous()})
+			if !p.match(COMMA) {
+				break
+			}
+		}
+	}
+
+	if _, err := p.consume(LEFT_BRACE, "Expect '{' before class body."); err != nil {
+		return nil, err
+	}
+
+	class := &Class{Name: name, Superclass: superclass, Traits: traits, Methods: []*Function{}}
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		if p.match(VAR) {
+			field, err := p.field()
+			if err != nil {
+				return nil, err
+			}
+			class.Fields = append(class.Fields, field)
+		} else if p.asyncMethod() {
+			method, err := p.asyncFunction("method")
+			if err != nil {
+				return nil, err
+			}
+			class.Methods = append(class.Methods, method)
+		} else if p.match(CLASS) {
+			// "class name() {}" declares a static method
+			method, err := p.function("method")
+			if err != nil {
+				return nil, err
+			}
+			class.ClassMethods = append(class.ClassMethods, method)
+		} else if (p.check(IDENTIFIER) || p.check(PRIVATE_IDENTIFIER)) && p.peekNext().Type == LEFT_BRACE {
+			getter, err := p.getter()
+			if err != nil {
+				return nil, err
+			}
+			class.Getters = append(class.Getters, getter)
+		} else if p.check(IDENTIFIER) && p.peek().Lexeme == "set" &&
+			(p.peekNext().Type == IDENTIFIER || p.peekNext().Type == PRIVATE_IDENTIFIER) {
+			setter, err := p.setter()
+			if err != nil {
+				return nil, err
+			}
+			class.Setters = append(class.Setters, setter)
+		} else {
+			method, err := p.function("method")
+			if err != nil {
+				return nil, err
+			}
+			class.Methods = append(class.Methods, method)
+		}
+	}
+
+	if _, err := p.consume(RIGHT_BRACE, "Expect '}' after class body."); err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+// field parses a field declaration in a class body
+func (p *Parser) field() (*Var, error) {
+	name, err := p.memberName("Expect field name.")
+	if err != nil {
+		return nil, err
+	}
+
+	var initializer Expr
+	if p.match(EQUAL) {
+		if initializer, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := p.consume(SEMICOLON, "Expect ';' after field declaration."); err != nil {
+		return nil, err
+	}
+	return &Var{Name: name, Initializer: initializer}, nil
+}
+
+// memberName consumes the name of a class member, which may be private
+func (p *Parser) memberName(message string) (Token, error) {
+	if p.match(PRIVATE_IDENTIFIER) {
+		return p.previous(), nil
+	}
+	return p.consume(IDENTIFIER, message)
+}
+
+// traitDeclaration parses a trait declaration, whose body holds only methods
+func (p *Parser) traitDeclaration() (Stmt, error) {
+	name, err := p.consume(IDENTIFIER, "Expect trait name.")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(LEFT_BRACE, "Expect '{' before trait body."); err != nil {
+		return nil, err
+	}
 
 	methods := []*Function{}
 	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
-		methods = append(methods, p.function("method").(*Function))
+		method, err := p.function("method")
+		if err != nil {
+			return nil, err
+		}
+		if method.Name.Type == PRIVATE_IDENTIFIER {
+			p.error(method.Name, "A trait method can't be private.")
+		}
+		methods = append(methods, method)
 	}
 
-	p.consume(RIGHT_BRACE, "Expect '}' after class body.")
-	return &Class{Name: name, Superclass: superclass, Methods: methods}
+	if _, err := p.consume(RIGHT_BRACE, "Expect '}' after trait body."); err != nil {
+		return nil, err
+	}
+	return &Trait{Name: name, Methods: methods}, nil
+}
+
+// getter parses a getter, which is a method name followed directly by its body
+func (p *Parser) getter() (*Function, error) {
+	name, err := p.memberName("Expect getter name.")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(LEFT_BRACE, "Expect '{' before getter body."); err != nil {
+		return nil, err
+	}
+	body, err := p.functionBody(false, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Function{Name: name, Params: nil, Body: body}, nil
+}
+
+// setter parses a setter of the form "set name(value) { ... }"
+func (p *Parser) setter() (*Function, error) {
+	p.advance() // "set"
+	setter, err := p.function("setter")
+	if err != nil {
+		return nil, err
+	}
+	if len(setter.Params) != 1 {
+		p.error(setter.Name, "A setter must have exactly one parameter.")
+	}
+	return setter, nil
 }
 
 // function parses a function declaration
-func (p *Parser) function(kind string) Stmt {
-	name := p.consume(IDENTIFIER, "Expect "+kind+" name.")
-	p.consume(LEFT_PAREN, "Expect '(' after "+kind+" name.")
+func (p *Parser) function(kind string) (*Function, error) {
+	return p.functionDeclaration(kind, false)
+}
+
+// asyncFunction parses the rest of a function declared with "async"
+func (p *Parser) asyncFunction(kind string) (*Function, error) {
+	function, err := p.functionDeclaration(kind, true)
+	if err != nil {
+		return nil, err
+	}
+	if function.IsGenerator {
+		p.error(function.Name, "A generator can't be async.")
+	}
+	return function, nil
+}
+
+// asyncMethod matches the "async" starting an async method in a class body, as
+// opposed to a method or getter named async
+func (p *Parser) asyncMethod() bool {
+	switch p.peekNext().Type {
+	case IDENTIFIER, PRIVATE_IDENTIFIER, STAR:
+		return p.match(ASYNC)
+	}
+	return false
+}
+
+// functionDeclaration parses a function declaration, which is async if isAsync is set
+func (p *Parser) functionDeclaration(kind string, isAsync bool) (*Function, error) {
+	// A '*' before the name declares a generator
+	isGenerator := p.match(STAR)
+	var name Token
+	var err error
+	if kind == "function" {
+		name, err = p.consume(IDENTIFIER, "Expect "+kind+" name.")
+	} else {
+		name, err = p.memberName("Expect " + kind + " name.")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(LEFT_PAREN, "Expect '(' after "+kind+" name."); err != nil {
+		return nil, err
+	}
 
 	parameters := []Token{}
 	if !p.check(RIGHT_PAREN) {
 		for {
-			parameters = append(parameters, p.consume(IDENTIFIER, "Expect parameter name."))
+			parameter, err := p.consume(IDENTIFIER, "Expect parameter name.")
+			if err != nil {
+				return nil, err
+			}
+			parameters = append(parameters, parameter)
 			if !p.match(COMMA) {
 				break
 			}
 		}
 	}
-	p.consume(RIGHT_PAREN, "Expect ')' after parameters.")
+	if _, err := p.consume(RIGHT_PAREN, "Expect ')' after parameters."); err != nil {
+		return nil, err
+	}
 
-	p.consume(LEFT_BRACE, "Expect '{' before "+kind+" body.")
-	body := p.blockStatement().(*Block).Statements
+	if _, err := p.consume(LEFT_BRACE, "Expect '{' before "+kind+" body."); err != nil {
+		return nil, err
+	}
+	body, err := p.functionBody(isGenerator, isAsync)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Function{Name: name, Params: parameters, Body: body}
+	return &Function{Name: name, Params: parameters, Body: body, IsGenerator: isGenerator, IsAsync: isAsync}, nil
+}
+
+// functionBody parses the block of a function, noting whether it's a generator's
+// or an async function's for the yields and awaits in it
+func (p *Parser) functionBody(isGenerator, isAsync bool) ([]Stmt, error) {
+	inGenerator, inAsync := p.inGenerator, p.inAsync
+	p.inGenerator, p.inAsync = isGenerator, isAsync
+	defer func() { p.inGenerator, p.inAsync = inGenerator, inAsync }()
+	return p.block()
 }
 
 // statement parses a statement
-func (p *Parser) statement() Stmt {
+func (p *Parser) statement() (Stmt, error) {
 	if p.match(PRINT) {
 		return p.printStatement()
 	}
@@ -145,19 +390,49 @@ func (p *Parser) statement() Stmt {
 		return p.returnStatement()
 	}
 
+	// Outside a generator, "yield" is a name unless what follows can't continue
+	// an expression, in which case the resolver reports the misplaced yield
+	if (p.inGenerator || p.check(YIELD) && (p.beforeOperand() || p.peekNext().Type == SEMICOLON)) && p.match(YIELD) {
+		return p.yieldStatement()
+	}
+
+	// "break;" and "continue;" would do nothing as expressions, so they're always
+	// the statements
+	if p.peekNext().Type == SEMICOLON && p.match(BREAK) {
+		keyword := p.previous()
+		if _, err := p.consume(SEMICOLON, "Expect ';' after 'break'."); err != nil {
+			return nil, err
+		}
+		return &Break{Keyword: keyword}, nil
+	}
+
+	if p.peekNext().Type == SEMICOLON && p.match(CONTINUE) {
+		keyword := p.previous()
+		if _, err := p.consume(SEMICOLON, "Expect ';' after 'continue'."); err != nil {
+			return nil, err
+		}
+		return &Continue{Keyword: keyword}, nil
+	}
+
 	if p.match(WHILE) {
 		return p.whileStatement()
 	}
 
 	if p.match(LEFT_BRACE) {
-		return p.blockStatement()
+		statements, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &Block{Statements: statements}, nil
 	}
 
 	return p.expressionStatement()
 }
 
-// blockStatement parses a block statement
-func (p *Parser) blockStatement() Stmt {
+// block parses the statements of a block up to its closing brace. A declaration
+// with a syntax error is reported and skipped, so the error only ends the block
+// if its closing brace is missing.
+func (p *Parser) block() ([]Stmt, error) {
 	statements := []Stmt{}
 
 	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
@@ -167,86 +442,124 @@ func (p *Parser) blockStatement() Stmt {
 		}
 	}
 
-	p.consume(RIGHT_BRACE, "Expect '}' after block.")
-	return &Block{Statements: statements}
+	if _, err := p.consume(RIGHT_BRACE, "Expect '}' after block."); err != nil {
+		return nil, err
+	}
+	return statements, nil
 }
 
 // ifStatement parses an if statement
-func (p *Parser) ifStatement() Stmt {
-	p.consume(LEFT_PAREN, "Expect '(' after 'if'.")
-	condition := p.expression()
-	p.consume(RIGHT_PAREN, "Expect ')' after if condition.")
+func (p *Parser) ifStatement() (Stmt, error) {
+	condition, err := p.parenthesized("'if'", "if condition")
+	if err != nil {
+		return nil, err
+	}
 
-	thenBranch := p.statement()
+	thenBranch, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
 	var elseBranch Stmt
 	if p.match(ELSE) {
-		elseBranch = p.statement()
+		if elseBranch, err = p.statement(); err != nil {
+			return nil, err
+		}
 	}
 
-	return &If{Condition: condition, ThenBranch: thenBranch, ElseBranch: elseBranch}
+	return &If{Condition: condition, ThenBranch: thenBranch, ElseBranch: elseBranch}, nil
 }
 
 // whileStatement parses a while statement
-func (p *Parser) whileStatement() Stmt {
-	p.consume(LEFT_PAREN, "Expect '(' after 'while'.")
-	condition := p.expression()
-	p.consume(RIGHT_PAREN, "Expect ')' after condition.")
+func (p *Parser) whileStatement() (Stmt, error) {
+	condition, err := p.parenthesized("'while'", "condition")
+	if err != nil {
+		return nil, err
+	}
 
-	body := p.statement()
+	body, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
 
-	return &While{Condition: condition, Body: body}
+	return &While{Condition: condition, Body: body}, nil
+}
+
+// parenthesized parses the parenthesized condition following a keyword
+func (p *Parser) parenthesized(after, what string) (Expr, error) {
+	if _, err := p.consume(LEFT_PAREN, "Expect '(' after "+after+"."); err != nil {
+		return nil, err
+	}
+	condition, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(RIGHT_PAREN, "Expect ')' after "+what+"."); err != nil {
+		return nil, err
+	}
+	return condition, nil
 }
 
 // forStatement parses a for statement and desugars it into a while loop
-func (p *Parser) forStatement() Stmt {
-	p.consume(LEFT_PAREN, "Expect '(' after 'for'.")
+func (p *Parser) forStatement() (Stmt, error) {
+	if _, err := p.consume(LEFT_PAREN, "Expect '(' after 'for'."); err != nil {
+		return nil, err
+	}
 
 	// Parse initializer (can be var declaration, expression, or omitted with ;)
 	var initializer Stmt
+	var err error
 	if p.match(SEMICOLON) {
 		// No initializer
 		initializer = nil
 	} else if p.match(VAR) {
-		initializer = p.varDeclaration()
+		if p.check(IDENTIFIER) && p.isContextual(p.peekNext(), IN) {
+			return p.forInStatement()
+		}
+		initializer, err = p.varDeclaration()
 	} else {
-		initializer = p.expressionStatement()
+		initializer, err = p.expressionStatement()
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// Parse condition (can be omitted)
 	var condition Expr
 	if !p.check(SEMICOLON) {
-		condition = p.expression()
+		if condition, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.consume(SEMICOLON, "Expect ';' after loop condition."); err != nil {
+		return nil, err
 	}
-	p.consume(SEMICOLON, "Expect ';' after loop condition.")
 
 	// Parse increment (can be omitted)
 	var increment Expr
 	if !p.check(RIGHT_PAREN) {
-		increment = p.expression()
+		if increment, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.consume(RIGHT_PAREN, "Expect ')' after for clauses."); err != nil {
+		return nil, err
 	}
-	p.consume(RIGHT_PAREN, "Expect ')' after for clauses.")
 
 	// Parse body
-	body := p.statement()
-
-	// Desugar the for loop into a while loop
-	// If there's an increment, wrap the body with it
-	if increment != nil {
-		body = &Block{
-			Statements: []Stmt{
-				body,
-				&Expression{Expression: increment},
-			},
-		}
+	body, err := p.statement()
+	if err != nil {
+		return nil, err
 	}
 
+	// Desugar the for loop into a while loop; the loop runs the increment itself so
+	// that continue doesn't skip it
 	// If there's no condition, use true
 	if condition == nil {
 		condition = &Literal{Value: true}
 	}
 
 	// Create the while loop
-	body = &While{Condition: condition, Body: body}
+	body = &While{Condition: condition, Body: body, Increment: increment}
 
 	// If there's an initializer, wrap everything in a block
 	if initializer != nil {
@@ -258,163 +571,234 @@ func (p *Parser) forStatement() Stmt {
 		}
 	}
 
-	return body
+	return body, nil
+}
+
+// forInStatement parses the rest of a "for (var name in iterable) body" loop
+func (p *Parser) forInStatement() (Stmt, error) {
+	name, err := p.consume(IDENTIFIER, "Expect variable name.")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(IN, "Expect 'in' after loop variable."); err != nil {
+		return nil, err
+	}
+	iterable, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.consume(RIGHT_PAREN, "Expect ')' after for clauses."); err != nil {
+		return nil, err
+	}
+
+	body, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ForIn{Name: name, Iterable: iterable, Body: body}, nil
 }
 
 // printStatement parses a print statement
-func (p *Parser) printStatement() Stmt {
-	expr := p.expression()
-	p.consume(SEMICOLON, "Expect ';' after value.")
-	return &Print{Expression: expr}
+func (p *Parser) printStatement() (Stmt, error) {
+	expr, err := p.terminated("Expect ';' after value.")
+	if err != nil {
+		return nil, err
+	}
+	return &Print{Expression: expr}, nil
 }
 
 // returnStatement parses a return statement
-func (p *Parser) returnStatement() Stmt {
+func (p *Parser) returnStatement() (Stmt, error) {
 	keyword := p.previous()
-	var value Expr
-	if !p.check(SEMICOLON) {
-		value = p.expression()
+	value, err := p.optionalValue("Expect ';' after return value.")
+	if err != nil {
+		return nil, err
 	}
-	p.consume(SEMICOLON, "Expect ';' after return value.")
-	return &Return{Keyword: keyword, Value: value}
+	return &Return{Keyword: keyword, Value: value}, nil
+}
+
+// yieldStatement parses a yield statement
+func (p *Parser) yieldStatement() (Stmt, error) {
+	keyword := p.previous()
+	value, err := p.optionalValue("Expect ';' after yield value.")
+	if err != nil {
+		return nil, err
+	}
+	return &Yield{Keyword: keyword, Value: value}, nil
 }
 
 // expressionStatement parses an expression statement
-func (p *Parser) expressionStatement() Stmt {
-	expr := p.expression()
-	p.consume(SEMICOLON, "Expect ';' after expression.")
-	return &Expression{Expression: expr}
+func (p *Parser) expressionStatement() (Stmt, error) {
+	expr, err := p.terminated("Expect ';' after expression.")
+	if err != nil {
+		return nil, err
+	}
+	return &Expression{Expression: expr}, nil
 }
 
-// consume checks if the current token is of the expected type and advances
-func (p *Parser) consume(tokenType TokenType, message string) Token {
-	if p.check(tokenType) {
-		return p.advance()
+// terminated parses an expression followed by a semicolon
+func (p *Parser) terminated(message string) (Expr, error) {
+	expr, err := p.expression()
+	if err != nil {
+		return nil, err
 	}
+	if _, err := p.consume(SEMICOLON, message); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// optionalValue parses the value of a return or yield, if it has one, and the
+// semicolon ending the statement
+func (p *Parser) optionalValue(message string) (Expr, error) {
+	if p.match(SEMICOLON) {
+		return nil, nil
+	}
+	return p.terminated(message)
+}
 
-	p.error(p.peek(), message)
-	panic("parse error")
+// consume checks if the current token is of the expected type and advances,
+// returning a syntax error with the message if it isn't
+func (p *Parser) consume(tokenType TokenType, message string) (Token, error) {
+	if p.check(tokenType) {
+		return p.advance(), nil
+	}
+	return Token{}, &parseError{token: p.peek(), message: message}
 }
 
 // expression parses an expression
-func (p *Parser) expression() Expr {
+func (p *Parser) expression() (Expr, error) {
 	return p.assignment()
 }
 
 // assignment parses assignment expressions (=)
-func (p *Parser) assignment() Expr {
-	expr := p.or()
+func (p *Parser) assignment() (Expr, error) {
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
 
 	if p.match(EQUAL) {
 		equals := p.previous()
-		value := p.assignment() // Right-associative, so we recursively call assignment()
+		value, err := p.assignment() // Right-associative, so we recursively call assignment()
+		if err != nil {
+			return nil, err
+		}
 
 		// Check if the left side is a variable
 		if variable, ok := expr.(*Variable); ok {
-			return &Assignment{Name: variable.Name, Value: value}
+			return &Assignment{Name: variable.Name, Value: value}, nil
 		}
 
 		// Check if the left side is a property access (get expression)
 		if get, ok := expr.(*Get); ok {
-			return &Set{Object: get.Object, Name: get.Name, Value: value}
+			return &Set{Object: get.Object, Name: get.Name, Value: value}, nil
 		}
 
 		// If it's not a variable or property, report an error
 		p.error(equals, "Invalid assignment target.")
 	}
 
-	return expr
+	return expr, nil
 }
 
-// or parses logical OR expressions (or)
-func (p *Parser) or() Expr {
-	expr := p.and()
-
-	for p.match(OR) {
-		operator := p.previous()
-		right := p.and()
-		expr = &Logical{Left: expr, Operator: operator, Right: right}
+// binary parses a left-associative chain of binary operators whose operands are
+// parsed by operand, building nodes with build
+func (p *Parser) binary(operand func() (Expr, error), build func(left Expr, operator Token, right Expr) Expr, operators ...TokenType) (Expr, error) {
+	expr, err := operand()
+	if err != nil {
+		return nil, err
 	}
 
-	return expr
-}
-
-// and parses logical AND expressions (and)
-func (p *Parser) and() Expr {
-	expr := p.equality()
-
-	for p.match(AND) {
+	for p.match(operators...) {
 		operator := p.previous()
-		right := p.equality()
-		expr = &Logical{Left: expr, Operator: operator, Right: right}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		expr = build(expr, operator, right)
 	}
 
-	return expr
+	return expr, nil
 }
 
-// equality parses equality expressions (==, !=)
-func (p *Parser) equality() Expr {
-	expr := p.comparison()
+// logicalNode builds a logical expression node
+func logicalNode(left Expr, operator Token, right Expr) Expr {
+	return &Logical{Left: left, Operator: operator, Right: right}
+}
 
-	// Left-associative: keep consuming equality operators
-	for p.match(EQUAL_EQUAL, BANG_EQUAL) {
-		operator := p.previous()
-		right := p.comparison()
-		expr = &Binary{Left: expr, Operator: operator, Right: right}
-	}
+// binaryNode builds a binary expression node
+func binaryNode(left Expr, operator Token, right Expr) Expr {
+	return &Binary{Left: left, Operator: operator, Right: right}
+}
 
-	return expr
+// or parses logical OR expressions (or)
+func (p *Parser) or() (Expr, error) {
+	return p.binary(p.and, logicalNode, OR)
 }
 
-// comparison parses comparison expressions (>, <, >=, <=)
-func (p *Parser) comparison() Expr {
-	expr := p.term()
+// and parses logical AND expressions (and)
+func (p *Parser) and() (Expr, error) {
+	return p.binary(p.equality, logicalNode, AND)
+}
 
-	// Left-associative: keep consuming comparison operators
-	for p.match(GREATER, GREATER_EQUAL, LESS, LESS_EQUAL) {
-		operator := p.previous()
-		right := p.term()
-		expr = &Binary{Left: expr, Operator: operator, Right: right}
-	}
+// equality parses equality expressions (==, !=)
+func (p *Parser) equality() (Expr, error) {
+	return p.binary(p.comparison, binaryNode, EQUAL_EQUAL, BANG_EQUAL)
+}
 
-	return expr
+// comparison parses comparison expressions (>, <, >=, <=, instanceof)
+func (p *Parser) comparison() (Expr, error) {
+	return p.binary(p.term, binaryNode, GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, INSTANCEOF)
 }
 
 // term parses addition and subtraction expressions (+, -)
-func (p *Parser) term() Expr {
-	expr := p.factor()
-
-	// Left-associative: keep consuming + and - operators
-	for p.match(PLUS, MINUS) {
-		operator := p.previous()
-		right := p.factor()
-		expr = &Binary{Left: expr, Operator: operator, Right: right}
-	}
-
-	return expr
+func (p *Parser) term() (Expr, error) {
+	return p.binary(p.factor, binaryNode, PLUS, MINUS)
 }
 
 // factor parses multiplication and division expressions (*, /)
-func (p *Parser) factor() Expr {
-	expr := p.unary()
-
-	// Left-associative: keep consuming * and / operators
-	for p.match(STAR, SLASH) {
-		operator := p.previous()
-		right := p.unary()
-		expr = &Binary{Left: expr, Operator: operator, Right: right}
-	}
-
-	return expr
+func (p *Parser) factor() (Expr, error) {
+	return p.binary(p.unary, binaryNode, STAR, SLASH)
 }
 
 // unary parses unary expressions (!, -)
-func (p *Parser) unary() Expr {
+func (p *Parser) unary() (Expr, error) {
 	// Check for unary operators
 	if p.match(BANG, MINUS) {
 		operator := p.previous()
-		right := p.unary() // Right-associative, so we call unary() recursively
-		return &Unary{Operator: operator, Right: right}
+		right, err := p.unary() // Right-associative, so we call unary() recursively
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{Operator: operator, Right: right}, nil
+	}
+
+	// "await" waits for a promise to settle. Outside an async function it's only
+	// a keyword in front of something that can't continue an expression.
+	if (p.inAsync || p.check(AWAIT) && p.beforeOperand()) && p.match(AWAIT) {
+		keyword := p.previous()
+		value, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Await{Keyword: keyword, Value: value}, nil
+	}
+
+	// "spawn" starts a call as a concurrent task
+	if p.check(SPAWN) && p.beforeOperand() && p.match(SPAWN) {
+		keyword := p.previous()
+		expr, err := p.call()
+		if err != nil {
+			return nil, err
+		}
+		call, ok := expr.(*Call)
+		if !ok {
+			p.error(keyword, "Expect function call after 'spawn'.")
+			return expr, nil
+		}
+		return &Spawn{Keyword: keyword, Call: call}, nil
 	}
 
 	// No unary operator, move to call
@@ -422,104 +806,151 @@ func (p *Parser) unary() Expr {
 }
 
 // call parses function call expressions
-func (p *Parser) call() Expr {
-	expr := p.primary()
+func (p *Parser) call() (Expr, error) {
+	expr, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
 
 	for {
 		if p.match(LEFT_PAREN) {
-			expr = p.finishCall(expr)
+			expr, err = p.finishCall(expr)
 		} else if p.match(DOT) {
-			name := p.consume(IDENTIFIER, "Expect property name after '.'.")
-			expr = &Get{Object: expr, Name: name}
+			var name Token
+			if name, err = p.memberName("Expect property name after '.'."); err == nil {
+				expr = &Get{Object: expr, Name: name}
+			}
+		} else if p.match(LEFT_BRACKET) {
+			expr, err = p.finishIndex(expr)
 		} else {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return expr
+	return expr, nil
 }
 
 // finishCall parses the arguments of a function call
-func (p *Parser) finishCall(callee Expr) Expr {
+func (p *Parser) finishCall(callee Expr) (Expr, error) {
 	arguments := []Expr{}
 
 	if !p.check(RIGHT_PAREN) {
 		for {
-			arguments = append(arguments, p.expression())
+			argument, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			arguments = append(arguments, argument)
 			if !p.match(COMMA) {
 				break
 			}
 		}
 	}
 
-	paren := p.consume(RIGHT_PAREN, "Expect ')' after arguments.")
+	paren, err := p.consume(RIGHT_PAREN, "Expect ')' after arguments.")
+	if err != nil {
+		return nil, err
+	}
 
-	return &Call{Callee: callee, Paren: paren, Arguments: arguments}
+	return &Call{Callee: callee, Paren: paren, Arguments: arguments}, nil
+}
+
+// finishIndex parses the index of a subscript expression
+func (p *Parser) finishIndex(object Expr) (Expr, error) {
+	index, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	bracket, err := p.consume(RIGHT_BRACKET, "Expect ']' after index.")
+	if err != nil {
+		return nil, err
+	}
+	return &Index{Object: object, Bracket: bracket, Index: index}, nil
 }
 
 // primary parses primary expressions (literals and grouping)
-func (p *Parser) primary() Expr {
+func (p *Parser) primary() (Expr, error) {
 	// Handle TRUE
 	if p.match(TRUE) {
-		return &Literal{Value: true}
+		return &Literal{Value: true}, nil
 	}
 
 	// Handle FALSE
 	if p.match(FALSE) {
-		return &Literal{Value: false}
+		return &Literal{Value: false}, nil
 	}
 
 	// Handle NIL
 	if p.match(NIL) {
-		return &Literal{Value: nil}
+		return &Literal{Value: nil}, nil
 	}
 
 	// Handle NUMBER
 	if p.match(NUMBER) {
-		// The previous token is the number we just matched
-		return &Literal{Value: p.previous().Literal}
+		// The scanner has already normalised the literal, so this can't fail
+		value, _ := strconv.ParseFloat(p.previous().Literal, 64)
+		return &Literal{Value: value}, nil
 	}
 
 	// Handle STRING
 	if p.match(STRING) {
-		return &Literal{Value: p.previous().Literal}
+		return &Literal{Value: p.previous().Literal}, nil
 	}
 
 	// Handle THIS keyword
 	if p.match(THIS) {
-		return &This{Keyword: p.previous()}
+		return &This{Keyword: p.previous()}, nil
 	}
 
 	// Handle SUPER keyword
 	if p.match(SUPER) {
 		keyword := p.previous()
-		p.consume(DOT, "Expect '.' after 'super'.")
-		method := p.consume(IDENTIFIER, "Expect superclass method name.")
-		return &Super{Keyword: keyword, Method: method}
+		if _, err := p.consume(DOT, "Expect '.' after 'super'."); err != nil {
+			return nil, err
+		}
+		method, err := p.consume(IDENTIFIER, "Expect superclass method name.")
+		if err != nil {
+			return nil, err
+		}
+		return &Super{Keyword: keyword, Method: method}, nil
 	}
 
 	// Handle IDENTIFIER - variable reference
 	if p.match(IDENTIFIER) {
-		return &Variable{Name: p.previous()}
+		return &Variable{Name: p.previous()}, nil
+	}
+
+	// Private names are only allowed as class members and after '.'
+	if p.check(PRIVATE_IDENTIFIER) {
+		return nil, &parseError{token: p.peek(), message: "Private name can only be used as a class member or after '.'."}
 	}
 
 	// Handle LEFT_PAREN - grouping expression
 	if p.match(LEFT_PAREN) {
-		expr := p.expression()
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
 		// Consume the closing RIGHT_PAREN
-		p.consume(RIGHT_PAREN, "Expect ')' after expression.")
-		return &Grouping{Expression: expr}
+		if _, err := p.consume(RIGHT_PAREN, "Expect ')' after expression."); err != nil {
+			return nil, err
+		}
+		return &Grouping{Expression: expr}, nil
 	}
 
-	// If we get here, we couldn't parse anything - report an error
-	p.error(p.peek(), "Expect expression.")
-	panic("parse error")
+	// If we get here, we couldn't parse anything
+	return nil, &parseError{token: p.peek(), message: "Expect expression."}
 }
 
-// match checks if the current token matches any of the given types
+// match checks if the current token matches any of the given types. A matched
+// contextual keyword becomes that keyword.
 func (p *Parser) match(types ...TokenType) bool {
 	for _, tokenType := range types {
 		if p.check(tokenType) {
+			p.tokens[p.current].Type = tokenType
 			p.advance()
 			return true
 		}
@@ -527,12 +958,32 @@ func (p *Parser) match(types ...TokenType) bool {
 	return false
 }
 
-// check returns true if the current token is of the given type
+// check returns true if the current token is of the given type. An identifier
+// spelling a contextual keyword also checks as that keyword; callers check what
+// follows it where it could be a name instead.
 func (p *Parser) check(tokenType TokenType) bool {
 	if p.isAtEnd() {
 		return false
 	}
-	return p.peek().Type == tokenType
+	return p.peek().Type == tokenType || p.isContextual(p.peek(), tokenType)
+}
+
+// isContextual reports whether a token is an identifier spelling the given
+// contextual keyword
+func (p *Parser) isContextual(token Token, keyword TokenType) bool {
+	word, ok := contextualKeywords[keyword]
+	return ok && token.Type == IDENTIFIER && token.Lexeme == word
+}
+
+// beforeOperand reports whether the token after the current one can start an
+// operand but can't continue an expression, so the current token can't be a
+// variable and must be a keyword applying to the operand
+func (p *Parser) beforeOperand() bool {
+	switch p.peekNext().Type {
+	case IDENTIFIER, NUMBER, STRING, THIS, SUPER, TRUE, FALSE, NIL, BANG:
+		return true
+	}
+	return false
 }
 
 // advance consumes the current token and returns it
@@ -553,6 +1004,14 @@ func (p *Parser) peek() Token {
 	return p.tokens[p.current]
 }
 
+// peekNext returns the token after the current one without consuming anything
+func (p *Parser) peekNext() Token {
+	if p.isAtEnd() {
+		return p.peek()
+	}
+	return p.tokens[p.current+1]
+}
+
 // previous returns the most recently consumed token
 func (p *Parser) previous() Token {
 	return p.tokens[p.current-1]
@@ -573,6 +1032,13 @@ func (p *Parser) error(token Token, message string) {
 	}
 }
 
+// report reports a syntax error returned up to the top of a declaration
+func (p *Parser) report(err error) {
+	if syntaxError, ok := err.(*parseError); ok {
+		p.error(syntaxError.token, syntaxError.message)
+	}
+}
+
 // reportError prints the error message to stderr
 func (p *Parser) reportError(token Token, where string, message string) {
 	fmt.Fprintf(os.Stderr, "[line %d] Error %s: %s\n", token.Line, where, message)
@@ -591,6 +1057,11 @@ func (p *Parser) synchronize() {
 		case CLASS, FUN, VAR, FOR, IF, WHILE, PRINT, RETURN:
 			return
 		}
+		for _, keyword := range []TokenType{TRAIT, ASYNC, YIELD, BREAK, CONTINUE} {
+			if p.isContextual(p.peek(), keyword) {
+				return
+			}
+		}
 
 		p.advance()
 	}
@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestPrivateNamesOnlyNameClassMembers(t *testing.T) {
+	rejected := []string{
+		"var #a = 1;",
+		"print #a;",
+		"fun #f() {}",
+		"fun f(#a) {}",
+		"class #A {}",
+		"trait T { #m() {} }",
+	}
+	for _, source := range rejected {
+		scanner := NewScanner(source)
+		scanner.AllowExtensions()
+		parser := NewParser(scanner.ScanTokens())
+		parser.ParseStatements()
+		if !parser.HasError() {
+			t.Errorf("%q parsed without an error", source)
+		}
+	}
+
+	scanner := NewScanner("class A { var #x = 1; #m() { return this.#x; } }")
+	scanner.AllowExtensions()
+	parser := NewParser(scanner.ScanTokens())
+	parser.ParseStatements()
+	if scanner.HasError() || parser.HasError() {
+		t.Errorf("private members failed to parse")
+	}
+}
+
+func TestTokenizeReportsHash(t *testing.T) {
+	scanner := NewScanner("#a")
+	scanner.ScanTokens()
+	if !scanner.HasError() {
+		t.Errorf("'#' was scanned without an error")
+	}
+}
+
+func TestParserRecoversAfterSyntaxError(t *testing.T) {
+	parser := NewParser(NewScanner("var = 1;\nprint (1 + ;\n{ print 2; var = 3; }\nprint 4;").ScanTokens())
+	statements := parser.ParseStatements()
+	if !parser.HasError() {
+		t.Fatalf("syntax errors weren't reported")
+	}
+
+	// Each broken declaration is skipped and parsing carries on after it
+	if len(statements) != 2 {
+		t.Fatalf("got %d statements, want the block and the last print", len(statements))
+	}
+	block, ok := statements[0].(*Block)
+	if !ok || len(block.Statements) != 1 {
+		t.Errorf("got %#v, want a block keeping its valid statement", statements[0])
+	}
+}
+
+func TestAddedKeywordsCanBeNames(t *testing.T) {
+	source := `
+var in = 1; var with = 2; var trait = 3; var spawn = 4; var yield = 5;
+var async = 6; var await = 7; var break = 8; var continue = 9; var instanceof = 10;
+print in + with + trait + spawn + yield + async + await + break + continue + instanceof;
+fun twice(spawn) { return spawn * 2; }
+print twice(await);
+yield = yield + 1;
+print yield;
+for (var in = 0; in < 2; in = in + 1) print in;
+class A { async() { return "method"; } await { return "getter"; } }
+print A().async();
+print A().await;
+fun* g() { yield (1); yield -2; }
+for (var in in g()) print in;
+`
+	want := "55\n14\n6\n0\n1\nmethod\ngetter\n1\n-2\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestTokenizeKeepsPlainLoxTokens(t *testing.T) {
+	scanner := NewScanner("in yield instanceof")
+	for _, token := range scanner.ScanTokens()[:3] {
+		if token.Type != IDENTIFIER {
+			t.Errorf("%q was scanned as %s, want IDENTIFIER", token.Lexeme, token.Type)
+		}
+	}
+
+	scanner = NewScanner("[1]")
+	scanner.ScanTokens()
+	if !scanner.HasError() {
+		t.Errorf("'[' was scanned without an error")
+	}
+}
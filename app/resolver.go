@@ -13,6 +13,9 @@ const (
 	FUNCTION
 	INITIALIZER
 	METHOD
+	STATIC_METHOD
+	GENERATOR
+	ASYNC_FUNCTION
 )
 
 // ClassType tracks whether we're currently inside a class
@@ -24,19 +27,28 @@ const (
 	IN_SUBCLASS
 )
 
+// localVariable is a variable declared in a local scope
+type localVariable struct {
+	slot    int  // index of the variable in its environment
+	defined bool // false while its initializer is being resolved
+}
+
 // Resolver performs static analysis to resolve variable bindings
 type Resolver struct {
 	interpreter     *Interpreter
-	scopes          []map[string]bool
+	scopes          []map[string]*localVariable
 	currentFunction FunctionType
 	currentClass    ClassType
+	inStaticMethod  bool
+	classStmt       *Class // innermost class declaration being resolved
+	loopDepth       int    // loops enclosing the current statement in this function
 	hadError        bool
 }
 
 func NewResolver(interpreter *Interpreter) *Resolver {
 	return &Resolver{
 		interpreter:     interpreter,
-		scopes:          []map[string]bool{},
+		scopes:          []map[string]*localVariable{},
 		currentFunction: NONE_FUNCTION,
 		currentClass:    NONE_CLASS,
 		hadError:        false,
@@ -73,7 +85,7 @@ func (r *Resolver) resolveExpr(expr Expr) {
 
 // beginScope starts a new scope
 func (r *Resolver) beginScope() {
-	r.scopes = append(r.scopes, make(map[string]bool))
+	r.scopes = append(r.scopes, make(map[string]*localVariable))
 }
 
 // endScope ends the current scope
@@ -81,7 +93,8 @@ func (r *Resolver) endScope() {
 	r.scopes = r.scopes[:len(r.scopes)-1]
 }
 
-// declare adds a variable to the current scope as "not ready"
+// declare adds a variable to the current scope as "not ready". Each variable gets
+// the next slot in the scope, matching the order the interpreter defines them.
 func (r *Resolver) declare(name Token) {
 	if len(r.scopes) == 0 {
 		return
@@ -95,7 +108,7 @@ func (r *Resolver) declare(name Token) {
 		return
 	}
 
-	scope[name.Lexeme] = false
+	scope[name.Lexeme] = &localVariable{slot: len(scope)}
 }
 
 // define marks a variable in the current scope as "ready"
@@ -105,15 +118,22 @@ func (r *Resolver) define(name Token) {
 	}
 
 	scope := r.scopes[len(r.scopes)-1]
-	scope[name.Lexeme] = true
+	scope[name.Lexeme].defined = true
+}
+
+// defineImplicit adds a ready variable the interpreter binds itself, such as
+// "this" or "super", to the current scope
+func (r *Resolver) defineImplicit(name string) {
+	scope := r.scopes[len(r.scopes)-1]
+	scope[name] = &localVariable{slot: len(scope), defined: true}
 }
 
 // resolveLocal resolves a local variable
 func (r *Resolver) resolveLocal(expr Expr, name Token) {
 	for i := len(r.scopes) - 1; i >= 0; i-- {
-		if _, ok := r.scopes[i][name.Lexeme]; ok {
+		if variable, ok := r.scopes[i][name.Lexeme]; ok {
 			depth := len(r.scopes) - 1 - i
-			r.interpreter.resolve(expr, depth)
+			r.interpreter.resolve(expr, depth, variable.slot)
 			return
 		}
 	}
@@ -125,6 +145,19 @@ func (r *Resolver) resolveLocal(expr Expr, name Token) {
 func (r *Resolver) resolveFunction(function *Function, functionType FunctionType) {
 	enclosingFunction := r.currentFunction
 	r.currentFunction = functionType
+	if function.IsGenerator {
+		r.currentFunction = GENERATOR
+	}
+	if function.IsAsync {
+		if functionType == INITIALIZER {
+			r.error(function.Name, "An initializer can't be async.")
+		}
+		r.currentFunction = ASYNC_FUNCTION
+	}
+
+	// Loops outside the function can't be broken out of from inside it
+	enclosingLoopDepth := r.loopDepth
+	r.loopDepth = 0
 
 	r.beginScope()
 	for _, param := range function.Params {
@@ -135,6 +168,7 @@ func (r *Resolver) resolveFunction(function *Function, functionType FunctionType
 	r.endScope()
 
 	r.currentFunction = enclosingFunction
+	r.loopDepth = enclosingLoopDepth
 }
 
 // Statement visitor methods
@@ -182,16 +216,44 @@ func (r *Resolver) VisitClassStmt(stmt *Class) interface{} {
 			r.error(stmt.Superclass.Name, "A class can't inherit from itself.")
 		}
 		r.resolveExpr(stmt.Superclass)
+	}
 
+	for _, trait := range stmt.Traits {
+		r.resolveExpr(trait)
+	}
+
+	if stmt.Superclass != nil {
 		// Create a scope for "super"
 		r.beginScope()
-		r.scopes[len(r.scopes)-1]["super"] = true
+		r.defineImplicit("super")
 	}
 
-	// Resolve methods
-	for _, method := range stmt.Methods {
+	// Methods nested in a static method's body may use "this" again
+	enclosingStatic := r.inStaticMethod
+	r.inStaticMethod = false
+	enclosingClassStmt := r.classStmt
+	r.classStmt = stmt
+
+	// Field initializers are evaluated with "this" bound to the new instance
+	r.beginScope()
+	r.defineImplicit("this")
+	declared := make(map[string]bool)
+	for _, field := range stmt.Fields {
+		if declared[field.Name.Lexeme] {
+			r.error(field.Name, "Already a field with this name in this class.")
+		}
+		declared[field.Name.Lexeme] = true
+		if field.Initializer != nil {
+			r.resolveExpr(field.Initializer)
+		}
+	}
+	r.endScope()
+
+	// Resolve methods, getters and setters, which all see "this"
+	instanceMethods := append(append(append([]*Function{}, stmt.Methods...), stmt.Getters...), stmt.Setters...)
+	for _, method := range instanceMethods {
 		r.beginScope()
-		r.scopes[len(r.scopes)-1]["this"] = true
+		r.defineImplicit("this")
 
 		// Determine the function type based on method name
 		declaration := METHOD
@@ -203,6 +265,14 @@ func (r *Resolver) VisitClassStmt(stmt *Class) interface{} {
 		r.endScope()
 	}
 
+	// Static methods have no instance, so "this" is forbidden inside them
+	r.inStaticMethod = true
+	for _, method := range stmt.ClassMethods {
+		r.resolveFunction(method, STATIC_METHOD)
+	}
+	r.inStaticMethod = enclosingStatic
+	r.classStmt = enclosingClassStmt
+
 	// End super scope if we created one
 	if stmt.Superclass != nil {
 		r.endScope()
@@ -212,6 +282,37 @@ func (r *Resolver) VisitClassStmt(stmt *Class) interface{} {
 	return nil
 }
 
+// VisitTraitStmt resolves a trait declaration
+func (r *Resolver) VisitTraitStmt(stmt *Trait) interface{} {
+	r.declare(stmt.Name)
+	r.define(stmt.Name)
+
+	enclosingClass := r.currentClass
+	enclosingStatic := r.inStaticMethod
+	enclosingClassStmt := r.classStmt
+	r.currentClass = IN_CLASS
+	r.inStaticMethod = false
+	r.classStmt = nil
+
+	for _, method := range stmt.Methods {
+		r.beginScope()
+		r.defineImplicit("this")
+
+		declaration := METHOD
+		if method.Name.Lexeme == "init" {
+			declaration = INITIALIZER
+		}
+
+		r.resolveFunction(method, declaration)
+		r.endScope()
+	}
+
+	r.currentClass = enclosingClass
+	r.inStaticMethod = enclosingStatic
+	r.classStmt = enclosingClassStmt
+	return nil
+}
+
 // VisitExpressionStmt resolves an expression statement
 func (r *Resolver) VisitExpressionStmt(stmt *Expression) interface{} {
 	r.resolveExpr(stmt.Expression)
@@ -245,6 +346,31 @@ func (r *Resolver) VisitReturnStmt(stmt *Return) interface{} {
 		if r.currentFunction == INITIALIZER {
 			r.error(stmt.Keyword, "Can't return a value from an initializer.")
 		}
+		if r.currentFunction == GENERATOR {
+			r.error(stmt.Keyword, "Can't return a value from a generator.")
+		}
+		r.resolveExpr(stmt.Value)
+
+		// Nothing is left to do in the function after a call in tail position, so
+		// the interpreter can run it in place of the returning function. Async
+		// functions are excluded since their result goes to a promise.
+		if _, ok := stmt.Value.(*Call); ok {
+			switch r.currentFunction {
+			case FUNCTION, METHOD, STATIC_METHOD:
+				stmt.IsTailCall = true
+			}
+		}
+	}
+	return nil
+}
+
+// VisitYieldStmt resolves a yield statement
+func (r *Resolver) VisitYieldStmt(stmt *Yield) interface{} {
+	if r.currentFunction != GENERATOR {
+		r.error(stmt.Keyword, "Can't use 'yield' outside of a generator.")
+	}
+
+	if stmt.Value != nil {
 		r.resolveExpr(stmt.Value)
 	}
 	return nil
@@ -253,7 +379,43 @@ func (r *Resolver) VisitReturnStmt(stmt *Return) interface{} {
 // VisitWhileStmt resolves a while statement
 func (r *Resolver) VisitWhileStmt(stmt *While) interface{} {
 	r.resolveExpr(stmt.Condition)
+	if stmt.Increment != nil {
+		r.resolveExpr(stmt.Increment)
+	}
+
+	r.loopDepth++
+	r.resolveStmt(stmt.Body)
+	r.loopDepth--
+	return nil
+}
+
+// VisitBreakStmt resolves a break statement
+func (r *Resolver) VisitBreakStmt(stmt *Break) interface{} {
+	if r.loopDepth == 0 {
+		r.error(stmt.Keyword, "Can't use 'break' outside of a loop.")
+	}
+	return nil
+}
+
+// VisitContinueStmt resolves a continue statement
+func (r *Resolver) VisitContinueStmt(stmt *Continue) interface{} {
+	if r.loopDepth == 0 {
+		r.error(stmt.Keyword, "Can't use 'continue' outside of a loop.")
+	}
+	return nil
+}
+
+// VisitForInStmt resolves a for-in loop, whose variable lives in its own scope
+func (r *Resolver) VisitForInStmt(stmt *ForIn) interface{} {
+	r.resolveExpr(stmt.Iterable)
+
+	r.beginScope()
+	r.declare(stmt.Name)
+	r.define(stmt.Name)
+	r.loopDepth++
 	r.resolveStmt(stmt.Body)
+	r.loopDepth--
+	r.endScope()
 	return nil
 }
 
@@ -263,7 +425,7 @@ func (r *Resolver) VisitWhileStmt(stmt *While) interface{} {
 func (r *Resolver) VisitVariableExpr(expr *Variable) interface{} {
 	if len(r.scopes) > 0 {
 		scope := r.scopes[len(r.scopes)-1]
-		if ready, ok := scope[expr.Name.Lexeme]; ok && !ready {
+		if variable, ok := scope[expr.Name.Lexeme]; ok && !variable.defined {
 			r.error(expr.Name, "Can't read local variable in its own initializer.")
 		}
 	}
@@ -277,6 +439,9 @@ func (r *Resolver) VisitThisExpr(expr *This) interface{} {
 	if r.currentClass == NONE_CLASS {
 		r.error(expr.Keyword, "Can't use 'this' outside of a class.")
 		return nil
+	} else if r.inStaticMethod {
+		r.error(expr.Keyword, "Can't use 'this' in a static method.")
+		return nil
 	}
 
 	r.resolveLocal(expr, expr.Keyword)
@@ -291,6 +456,9 @@ func (r *Resolver) VisitSuperExpr(expr *Super) interface{} {
 	} else if r.currentClass != IN_SUBCLASS {
 		r.error(expr.Keyword, "Can't use 'super' in a class with no superclass.")
 		return nil
+	} else if r.inStaticMethod {
+		r.error(expr.Keyword, "Can't use 'super' in a static method.")
+		return nil
 	}
 
 	r.resolveLocal(expr, expr.Keyword)
@@ -325,6 +493,7 @@ func (r *Resolver) VisitCallExpr(expr *Call) interface{} {
 // VisitGetExpr resolves a property access expression
 func (r *Resolver) VisitGetExpr(expr *Get) interface{} {
 	r.resolveExpr(expr.Object)
+	r.resolvePrivate(expr, expr.Name)
 	return nil
 }
 
@@ -332,6 +501,66 @@ func (r *Resolver) VisitGetExpr(expr *Get) interface{} {
 func (r *Resolver) VisitSetExpr(expr *Set) interface{} {
 	r.resolveExpr(expr.Value)
 	r.resolveExpr(expr.Object)
+	r.resolvePrivate(expr, expr.Name)
+	return nil
+}
+
+// resolvePrivate checks that a private member is only accessed inside the body of
+// the class that declares it, and records that class for the runtime check
+func (r *Resolver) resolvePrivate(expr Expr, name Token) {
+	if !isPrivateName(name) {
+		return
+	}
+
+	if r.classStmt == nil {
+		r.error(name, "Can't access private member outside of a class.")
+		return
+	}
+	if !classDeclaresMember(r.classStmt, name.Lexeme) {
+		r.error(name, fmt.Sprintf("Private member is not declared in class '%s'.", r.classStmt.Name.Lexeme))
+		return
+	}
+
+	r.interpreter.resolvePrivate(expr, r.classStmt)
+}
+
+// classDeclaresMember reports whether a class body declares a field or method
+func classDeclaresMember(class *Class, name string) bool {
+	for _, field := range class.Fields {
+		if field.Name.Lexeme == name {
+			return true
+		}
+	}
+	for _, methods := range [][]*Function{class.Methods, class.ClassMethods, class.Getters, class.Setters} {
+		for _, method := range methods {
+			if method.Name.Lexeme == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VisitAwaitExpr resolves an await expression, which is allowed in async functions
+// and in top-level code
+func (r *Resolver) VisitAwaitExpr(expr *Await) interface{} {
+	if r.currentFunction != ASYNC_FUNCTION && r.currentFunction != NONE_FUNCTION {
+		r.error(expr.Keyword, "Can't use 'await' outside of an async function.")
+	}
+	r.resolveExpr(expr.Value)
+	return nil
+}
+
+// VisitSpawnExpr resolves a spawn expression
+func (r *Resolver) VisitSpawnExpr(expr *Spawn) interface{} {
+	r.resolveExpr(expr.Call)
+	return nil
+}
+
+// VisitIndexExpr resolves a subscript expression
+func (r *Resolver) VisitIndexExpr(expr *Index) interface{} {
+	r.resolveExpr(expr.Object)
+	r.resolveExpr(expr.Index)
 	return nil
 }
 
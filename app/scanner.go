@@ -11,17 +11,19 @@ type TokenType string
 
 const (
 	// Single-character tokens
-	LEFT_PAREN  TokenType = "LEFT_PAREN"
-	RIGHT_PAREN TokenType = "RIGHT_PAREN"
-	LEFT_BRACE  TokenType = "LEFT_BRACE"
-	RIGHT_BRACE TokenType = "RIGHT_BRACE"
-	COMMA       TokenType = "COMMA"
-	DOT         TokenType = "DOT"
-	MINUS       TokenType = "MINUS"
-	PLUS        TokenType = "PLUS"
-	SEMICOLON   TokenType = "SEMICOLON"
-	SLASH       TokenType = "SLASH"
-	STAR        TokenType = "STAR"
+	LEFT_PAREN    TokenType = "LEFT_PAREN"
+	RIGHT_PAREN   TokenType = "RIGHT_PAREN"
+	LEFT_BRACE    TokenType = "LEFT_BRACE"
+	RIGHT_BRACE   TokenType = "RIGHT_BRACE"
+	LEFT_BRACKET  TokenType = "LEFT_BRACKET"
+	RIGHT_BRACKET TokenType = "RIGHT_BRACKET"
+	COMMA         TokenType = "COMMA"
+	DOT           TokenType = "DOT"
+	MINUS         TokenType = "MINUS"
+	PLUS          TokenType = "PLUS"
+	SEMICOLON     TokenType = "SEMICOLON"
+	SLASH         TokenType = "SLASH"
+	STAR          TokenType = "STAR"
 
 	// One or two character tokens
 	BANG          TokenType = "BANG"
@@ -38,23 +40,35 @@ const (
 	NUMBER     TokenType = "NUMBER"
 	IDENTIFIER TokenType = "IDENTIFIER"
 
+	PRIVATE_IDENTIFIER TokenType = "PRIVATE_IDENTIFIER" // "#name", a private class member
+
 	// Keywords
-	AND    TokenType = "AND"
-	CLASS  TokenType = "CLASS"
-	ELSE   TokenType = "ELSE"
-	FALSE  TokenType = "FALSE"
-	FOR    TokenType = "FOR"
-	FUN    TokenType = "FUN"
-	IF     TokenType = "IF"
-	NIL    TokenType = "NIL"
-	OR     TokenType = "OR"
-	PRINT  TokenType = "PRINT"
-	RETURN TokenType = "RETURN"
-	SUPER  TokenType = "SUPER"
-	THIS   TokenType = "THIS"
-	TRUE   TokenType = "TRUE"
-	VAR    TokenType = "VAR"
-	WHILE  TokenType = "WHILE"
+	AND        TokenType = "AND"
+	ASYNC      TokenType = "ASYNC"
+	AWAIT      TokenType = "AWAIT"
+	BREAK      TokenType = "BREAK"
+	CLASS      TokenType = "CLASS"
+	CONTINUE   TokenType = "CONTINUE"
+	ELSE       TokenType = "ELSE"
+	FALSE      TokenType = "FALSE"
+	FOR        TokenType = "FOR"
+	FUN        TokenType = "FUN"
+	IF         TokenType = "IF"
+	IN         TokenType = "IN"
+	INSTANCEOF TokenType = "INSTANCEOF"
+	NIL        TokenType = "NIL"
+	OR         TokenType = "OR"
+	PRINT      TokenType = "PRINT"
+	RETURN     TokenType = "RETURN"
+	SPAWN      TokenType = "SPAWN"
+	SUPER      TokenType = "SUPER"
+	THIS       TokenType = "THIS"
+	TRAIT      TokenType = "TRAIT"
+	TRUE       TokenType = "TRUE"
+	VAR        TokenType = "VAR"
+	WHILE      TokenType = "WHILE"
+	WITH       TokenType = "WITH"
+	YIELD      TokenType = "YIELD"
 
 	// Special token
 	EOF TokenType = "EOF"
@@ -86,13 +100,31 @@ var keywords = map[string]TokenType{
 	"while":  WHILE,
 }
 
+// contextualKeywords are the keywords added to Lox after its reserved words. They
+// are only keywords where the grammar expects them, so scripts that use them as
+// names keep working: the scanner reads them as identifiers, and the parser turns
+// one into its keyword when it matches it there.
+var contextualKeywords = map[TokenType]string{
+	ASYNC:      "async",
+	AWAIT:      "await",
+	BREAK:      "break",
+	CONTINUE:   "continue",
+	IN:         "in",
+	INSTANCEOF: "instanceof",
+	SPAWN:      "spawn",
+	TRAIT:      "trait",
+	WITH:       "with",
+	YIELD:      "yield",
+}
+
 type Scanner struct {
-	source   string
-	tokens   []Token
-	start    int
-	current  int
-	line     int
-	hadError bool
+	source     string
+	tokens     []Token
+	start      int
+	current    int
+	line       int
+	hadError   bool
+	extensions bool // '#' starts a private name and '[' and ']' are tokens
 }
 
 func NewScanner(source string) *Scanner {
@@ -135,6 +167,14 @@ func (s *Scanner) scanToken() {
 		s.addToken(LEFT_BRACE, "null")
 	case '}':
 		s.addToken(RIGHT_BRACE, "null")
+	case '[', ']':
+		if !s.extensions {
+			s.reportError(fmt.Sprintf("Unexpected character: %c", c))
+		} else if c == '[' {
+			s.addToken(LEFT_BRACKET, "null")
+		} else {
+			s.addToken(RIGHT_BRACKET, "null")
+		}
 	case ',':
 		s.addToken(COMMA, "null")
 	case '.':
@@ -191,6 +231,8 @@ func (s *Scanner) scanToken() {
 			s.scanNumber()
 		} else if s.isAlpha(c) {
 			s.scanIdentifier()
+		} else if c == '#' && s.extensions && s.isAlpha(s.peek()) {
+			s.scanPrivateName()
 		} else {
 			s.reportError(fmt.Sprintf("Unexpected character: %c", c))
 		}
@@ -205,6 +247,14 @@ func (s *Scanner) advance() byte {
 
 func (s *Scanner) addToken(tokenType TokenType, literal string) {
 	text := s.source[s.start:s.current]
+
+	// Names and string literals are interned so equal ones share their bytes
+	if tokenType == IDENTIFIER || tokenType == PRIVATE_IDENTIFIER {
+		text = intern(text)
+	} else if tokenType == STRING {
+		literal = intern(literal)
+	}
+
 	s.tokens = append(s.tokens, Token{
 		Type:    tokenType,
 		Lexeme:  text,
@@ -323,6 +373,21 @@ func (s *Scanner) scanIdentifier() {
 	s.addToken(tokenType, "null")
 }
 
+// scanPrivateName scans a private class member name: '#' followed by an identifier
+func (s *Scanner) scanPrivateName() {
+	for s.isAlphaNumeric(s.peek()) {
+		s.advance()
+	}
+	s.addToken(PRIVATE_IDENTIFIER, "null")
+}
+
+// AllowExtensions makes the scanner read the characters plain Lox doesn't have:
+// "#name" as a private class member name and '[' and ']' for subscripts. Without
+// it they're reported as unexpected, as the tokenize command does.
+func (s *Scanner) AllowExtensions() {
+	s.extensions = true
+}
+
 func (t Token) String() string {
 	return fmt.Sprintf("%s %s %s", t.Type, t.Lexeme, t.Literal)
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+// runScript runs a Lox program with the given options and returns what it printed.
+// The test fails if the program doesn't parse, resolve or run cleanly.
+func runScript(t *testing.T, source string, options Options) string {
+	t.Helper()
+	printed, errors, failed := execScript(t, source, options)
+	if failed {
+		t.Fatalf("script failed at runtime with:\n%s\nit printed:\n%s", errors, printed)
+	}
+	return printed
+}
+
+// runFailingScript runs a Lox program that should stop with a runtime error and
+// returns what it printed and the error it reported
+func runFailingScript(t *testing.T, source string, options Options) (string, string) {
+	t.Helper()
+	printed, errors, failed := execScript(t, source, options)
+	if !failed {
+		t.Fatalf("script ran without a runtime error; it printed:\n%s", printed)
+	}
+	return printed, errors
+}
+
+// execScript runs a Lox program, returning what it wrote to stdout and stderr and
+// whether it stopped with a runtime error. The test fails if the program doesn't
+// parse or resolve.
+func execScript(t *testing.T, source string, options Options) (string, string, bool) {
+	t.Helper()
+	interpreter, printed, errors := interpretScript(t, source, options)
+	return printed, errors, interpreter.HasRuntimeError()
+}
+
+// interpretScript runs a Lox program like execScript, returning the interpreter
+// that ran it so a test can inspect what it was left with
+func interpretScript(t *testing.T, source string, options Options) (*Interpreter, string, string) {
+	t.Helper()
+
+	scanner := NewScanner(source)
+	scanner.AllowExtensions()
+	tokens := scanner.ScanTokens()
+	parser := NewParser(tokens)
+	statements := parser.ParseStatements()
+	if scanner.HasError() || parser.HasError() {
+		t.Fatalf("script doesn't parse:\n%s", source)
+	}
+
+	interpreter := NewInterpreterWithOptions(options)
+	resolver := NewResolver(interpreter)
+	resolver.Resolve(statements)
+	if resolver.HasError() {
+		t.Fatalf("script doesn't resolve:\n%s", source)
+	}
+	statements = NewOptimizer(interpreter).Optimize(statements)
+
+	// print writes to stdout and runtime errors go to stderr, so capture both for
+	// the length of the run
+	stdout, stdoutWriter := capture(t)
+	stderr, stderrWriter := capture(t)
+	savedStdout, savedStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdoutWriter, stderrWriter
+
+	interpreter.InterpretStatements(statements)
+	if !interpreter.HasRuntimeError() {
+		interpreter.RunEventLoop()
+	}
+
+	os.Stdout, os.Stderr = savedStdout, savedStderr
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	return interpreter, <-stdout, <-stderr
+}
+
+// compileErrors parses and resolves a Lox program that should be rejected before
+// it runs, returning the errors it reported
+func compileErrors(t *testing.T, source string) string {
+	t.Helper()
+	stderr, stderrWriter := capture(t)
+	savedStderr := os.Stderr
+	os.Stderr = stderrWriter
+
+	scanner := NewScanner(source)
+	scanner.AllowExtensions()
+	parser := NewParser(scanner.ScanTokens())
+	statements := parser.ParseStatements()
+	failed := scanner.HasError() || parser.HasError()
+	if !failed {
+		resolver := NewResolver(NewInterpreter())
+		resolver.Resolve(statements)
+		failed = resolver.HasError()
+	}
+
+	os.Stderr = savedStderr
+	stderrWriter.Close()
+	errors := <-stderr
+	if !failed {
+		t.Fatalf("script compiled without an error:\n%s", source)
+	}
+	return errors
+}
+
+// capture returns a pipe's writer and a channel receiving everything written to
+// it once it's closed
+func capture(t *testing.T) (<-chan string, *os.File) {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		output <- string(data)
+	}()
+	return output, writer
+}
@@ -13,9 +13,14 @@ type StmtVisitor interface {
 	VisitBlockStmt(stmt *Block) interface{}
 	VisitIfStmt(stmt *If) interface{}
 	VisitWhileStmt(stmt *While) interface{}
+	VisitForInStmt(stmt *ForIn) interface{}
 	VisitFunctionStmt(stmt *Function) interface{}
 	VisitReturnStmt(stmt *Return) interface{}
+	VisitYieldStmt(stmt *Yield) interface{}
 	VisitClassStmt(stmt *Class) interface{}
+	VisitTraitStmt(stmt *Trait) interface{}
+	VisitBreakStmt(stmt *Break) interface{}
+	VisitContinueStmt(stmt *Continue) interface{}
 }
 
 // Print represents a print statement
@@ -66,21 +71,36 @@ func (i *If) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitIfStmt(i)
 }
 
-// While represents a while statement
+// While represents a while statement. Increment is set for desugared for loops
+// and runs after each iteration, including one cut short by continue.
 type While struct {
 	Condition Expr
 	Body      Stmt
+	Increment Expr
 }
 
 func (w *While) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitWhileStmt(w)
 }
 
+// ForIn represents a for-in loop over an iterable value
+type ForIn struct {
+	Name     Token
+	Iterable Expr
+	Body     Stmt
+}
+
+func (f *ForIn) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitForInStmt(f)
+}
+
 // Function represents a function declaration statement
 type Function struct {
-	Name   Token
-	Params []Token
-	Body   []Stmt
+	Name        Token
+	Params      []Token
+	Body        []Stmt
+	IsGenerator bool // declared with "fun*", so calling it returns a generator
+	IsAsync     bool // declared with "async", so calling it returns a promise
 }
 
 func (f *Function) Accept(visitor StmtVisitor) interface{} {
@@ -89,21 +109,66 @@ func (f *Function) Accept(visitor StmtVisitor) interface{} {
 
 // Return represents a return statement
 type Return struct {
-	Keyword Token
-	Value   Expr
+	Keyword    Token
+	Value      Expr
+	IsTailCall bool // set by the resolver when the value is a call returned as is
 }
 
 func (r *Return) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitReturnStmt(r)
 }
 
+// Break represents a break statement, which leaves the innermost loop
+type Break struct {
+	Keyword Token
+}
+
+func (b *Break) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitBreakStmt(b)
+}
+
+// Continue represents a continue statement, which skips to the next iteration of
+// the innermost loop
+type Continue struct {
+	Keyword Token
+}
+
+func (c *Continue) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitContinueStmt(c)
+}
+
+// Yield represents a yield statement inside a generator function
+type Yield struct {
+	Keyword Token
+	Value   Expr
+}
+
+func (y *Yield) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitYieldStmt(y)
+}
+
 // Class represents a class declaration statement
 type Class struct {
-	Name       Token
-	Superclass *Variable
-	Methods    []*Function
+	Name         Token
+	Superclass   *Variable
+	Traits       []*Variable // traits mixed in with "with"
+	Methods      []*Function
+	ClassMethods []*Function // static methods, called on the class itself
+	Getters      []*Function // methods without a parameter list, run on property access
+	Setters      []*Function // single-parameter methods run on property assignment
+	Fields       []*Var      // declared fields with their default initializers
 }
 
 func (c *Class) Accept(visitor StmtVisitor) interface{} {
 	return visitor.VisitClassStmt(c)
 }
+
+// Trait represents a trait declaration, a named set of methods that classes can mix in
+type Trait struct {
+	Name    Token
+	Methods []*Function
+}
+
+func (t *Trait) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitTraitStmt(t)
+}
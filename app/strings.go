@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// internTable holds one canonical copy of every identifier and string literal seen
+// by a scanner. Equal strings that share their bytes compare without looking at
+// the bytes, which makes the map lookups for variables, fields and methods cheaper.
+var internTable sync.Map // string -> string
+
+// intern returns the canonical copy of s
+func intern(s string) string {
+	if canonical, ok := internTable.Load(s); ok {
+		return canonical.(string)
+	}
+	// Clone so the table doesn't keep the whole source file alive
+	canonical, _ := internTable.LoadOrStore(s, strings.Clone(s))
+	return canonical.(string)
+}
+
+// minBufferedConcat is the length below which concatenations are left to Go
+const minBufferedConcat = 64
+
+// concatBuffer makes building a string with repeated "+" linear rather than
+// quadratic. The result of a long concatenation is a view of the start of buf, and
+// when that result is the left operand of the next concatenation the right operand
+// is appended in place. Bytes are only ever written past the end of every view
+// handed out, so the strings already given to the script never change.
+type concatBuffer struct {
+	buf []byte
+}
+
+// concat returns left + right
+func (c *concatBuffer) concat(left, right string) string {
+	length := len(left) + len(right)
+	if length < minBufferedConcat {
+		return left + right
+	}
+
+	extendsBuffer := len(c.buf) > 0 && len(left) == len(c.buf) && unsafe.StringData(left) == &c.buf[0]
+	if extendsBuffer && cap(c.buf) >= length {
+		c.buf = append(c.buf, right...)
+	} else {
+		// Start a new buffer with room to grow; views of the old one stay valid
+		buf := make([]byte, 0, 2*length)
+		buf = append(buf, left...)
+		c.buf = append(buf, right...)
+	}
+	return unsafe.String(&c.buf[0], len(c.buf))
+}
+
+// LoxStringBuilder accumulates text for a string built piece by piece
+type LoxStringBuilder struct {
+	mu      sync.Mutex
+	builder strings.Builder
+}
+
+func (b *LoxStringBuilder) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.builder.String()
+}
+
+// stringBuilderMethod returns the built-in method with the given name bound to the
+// builder, or nil
+func stringBuilderMethod(builder *LoxStringBuilder, name string) *NativeFunction {
+	switch name {
+	case "append":
+		// Appends the string form of any value and returns the builder for chaining
+		return NewNativeFunction(name, 1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			text := interpreter.Stringify(arguments[0])
+			builder.mu.Lock()
+			defer builder.mu.Unlock()
+			builder.builder.WriteString(text)
+			return builder, nil
+		})
+	case "toString":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return builder.String(), nil
+		})
+	case "len":
+		// Length in characters, like the string method of the same name
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			return float64(len([]rune(builder.String()))), nil
+		})
+	case "clear":
+		return NewNativeFunction(name, 0, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+			builder.mu.Lock()
+			defer builder.mu.Unlock()
+			builder.builder.Reset()
+			return nil, nil
+		})
+	}
+	return nil
+}
+
+// defineStringBuilderNatives registers the StringBuilder constructor in the given
+// environment. StringBuilder() takes an optional initial string.
+func defineStringBuilderNatives(env *Environment) {
+	env.Define("StringBuilder", NewNativeFunction("StringBuilder", -1, func(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+		if len(arguments) > 1 {
+			return nil, fmt.Errorf("Expected 0 or 1 arguments but got %d.", len(arguments))
+		}
+		builder := &LoxStringBuilder{}
+		if len(arguments) == 1 {
+			initial, err := interpreter.stringArg("StringBuilder", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			builder.builder.WriteString(initial)
+		}
+		return builder, nil
+	}))
+}
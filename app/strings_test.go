@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestConcatenationKeepsEarlierStrings(t *testing.T) {
+	source := `
+var base = "0123456789012345678901234567890123456789012345678901234567890123456789";
+var a = base + "A";
+var b = a + "B";
+var c = a + "C";
+print b;
+print c;
+print a;
+var d = b + "D";
+print b;
+print d;
+print a == base + "A";
+var m = map();
+m.set(a, 1);
+print m.get(base + "A");
+`
+	// Extending a shared buffer from two places mustn't let one overwrite the other
+	base := "0123456789012345678901234567890123456789012345678901234567890123456789"
+	want := base + "AB\n" + base + "AC\n" + base + "A\n" + base + "AB\n" + base + "ABD\ntrue\n1\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestStringBuilder(t *testing.T) {
+	source := `
+var sb = StringBuilder("x");
+sb.append(1).append(nil).append(true).append(list(1));
+print sb;
+print sb.append("é").len();
+print type(sb);
+print sb == sb;
+print StringBuilder() == StringBuilder();
+sb.clear();
+print sb.toString() == "";
+`
+	want := "x1niltrue[1]\n13\nstringbuilder\ntrue\nfalse\ntrue\n"
+	if got := runScript(t, source, Options{}); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"StringBuilder(1, 2);", "Expected 0 or 1 arguments but got 2.\n[line 1]\n"},
+		{"StringBuilder(5);", "Argument 1 to 'StringBuilder' must be a string.\n[line 1]\n"},
+		{"StringBuilder().nope();", "Undefined property 'nope'.\n[line 1]\n"},
+		{"print StringBuilder() + \"x\";", "Operands must be two numbers or two strings.\n[line 1]\n"},
+	}
+	for _, test := range tests {
+		if _, errors := runFailingScript(t, test.source, Options{}); errors != test.want {
+			t.Errorf("%q: got error %q, want %q", test.source, errors, test.want)
+		}
+	}
+}